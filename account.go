@@ -0,0 +1,234 @@
+package glsl
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// minPasswordSize is the minimum size of local account passwords.
+	minPasswordSize = 8
+	// maxPasswordSize is the maximum size accepted by bcrypt.
+	maxPasswordSize = 72
+	// maxAccountName is the size of the account name column.
+	maxAccountName = 32
+)
+
+var (
+	// ErrInvalidLogin is returned when the account does not exist or the
+	// password is not correct. Both cases are not distinguished so account
+	// names cannot be discovered.
+	ErrInvalidLogin = errors.New("invalid account name or password")
+
+	accountNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	invalidNameChars  = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+	// passwordCost is the bcrypt cost of new password hashes.
+	passwordCost = bcrypt.DefaultCost
+	// dummyHash is compared with the password of missing accounts so they
+	// take the same time to check as existing ones.
+	dummyHash = []byte("$2a$10$WCJdv8/g4hktU0bhjxrbr.OuNp6LoxXa5hNkrHLWs5buDUc74gpJG")
+)
+
+// Account is a registered user. Its effects are the ones owned by its
+// Identity, that replaces the anonymous one of the client on login.
+type Account struct {
+	ID       uint `gorm:"primary_key"`
+	Created  time.Time
+	Name     string `gorm:"size:32;unique_index:account_name"`
+	Identity string `gorm:"size:64;unique_index:account_identity"`
+	// Password is the bcrypt hash of the password. It is empty for
+	// accounts that can only log in with an OAuth provider.
+	Password string `json:"-" gorm:"size:60"`
+}
+
+// AccountLogin links an account with a user of an OAuth provider.
+type AccountLogin struct {
+	ID        uint   `gorm:"primary_key"`
+	AccountID uint   `gorm:"index:login_account_id"`
+	Provider  string `gorm:"size:32;unique_index:login_subject"`
+	Subject   string `gorm:"size:255;unique_index:login_subject"`
+}
+
+// validAccountName checks that the name can be used in gallery urls.
+func validAccountName(name string) error {
+	if len(name) < 3 || len(name) > maxAccountName {
+		return invalidField("name",
+			"name must have between 3 and %v characters", maxAccountName)
+	}
+
+	if !accountNameRegexp.MatchString(name) {
+		return invalidField("name", "name can only contain lowercase "+
+			"letters, numbers, - and _, and must start with a letter or number")
+	}
+
+	return nil
+}
+
+// accountName converts the name given by an OAuth provider into a valid
+// account name.
+func accountName(name string) string {
+	name = invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	name = strings.TrimLeft(name, "-_")
+	if len(name) > maxAccountName {
+		name = name[:maxAccountName]
+	}
+
+	for len(name) < 3 {
+		name += "_"
+	}
+
+	return name
+}
+
+// newAccount builds an account with a new identity. The password is
+// hashed if not empty.
+func newAccount(name, password string) (*Account, error) {
+	identity, err := newIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	account := &Account{
+		Name:     name,
+		Identity: identity,
+	}
+
+	if password != "" {
+		if len(password) < minPasswordSize || len(password) > maxPasswordSize {
+			return nil, invalidField("password",
+				"password must have between %v and %v characters",
+				minPasswordSize, maxPasswordSize)
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+		if err != nil {
+			return nil, err
+		}
+		account.Password = string(hash)
+	}
+
+	return account, nil
+}
+
+// checkPassword returns the account if the password is correct or
+// ErrInvalidLogin otherwise.
+func checkPassword(db Store, name, password string) (*Account, error) {
+	account, err := db.Account(name)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	if account == nil || account.Password == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidLogin
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(password))
+	if err != nil {
+		return nil, ErrInvalidLogin
+	}
+
+	return account, nil
+}
+
+// NewAccount stores the account. ErrConflict is returned if the name is
+// already used.
+func (d *Database) NewAccount(a *Account) error {
+	a.Created = time.Now()
+
+	err := d.Create(a).Error
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		d.log().Errorf(err, "cannot create account %v", a.Name)
+		return err
+	}
+
+	return nil
+}
+
+func (d *Database) Account(name string) (*Account, error) {
+	return d.findAccount("name = ?", name)
+}
+
+func (d *Database) AccountByIdentity(identity string) (*Account, error) {
+	return d.findAccount("identity = ?", identity)
+}
+
+func (d *Database) AccountByLogin(provider, subject string) (*Account, error) {
+	var login AccountLogin
+	err := d.Where("provider = ? AND subject = ?", provider, subject).
+		First(&login).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		d.log().Errorf(err, "cannot retrieve %v login %v", provider, subject)
+		return nil, err
+	}
+
+	return d.findAccount("id = ?", login.AccountID)
+}
+
+func (d *Database) findAccount(query string, arg interface{}) (*Account, error) {
+	var account Account
+	err := d.Where(query, arg).First(&account).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		d.log().Errorf(err, "cannot retrieve account %v", arg)
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// AddLogin links the account to an OAuth provider user. ErrConflict is
+// returned if the user is already linked.
+func (d *Database) AddLogin(account uint, provider, subject string) error {
+	err := d.Create(&AccountLogin{
+		AccountID: account,
+		Provider:  provider,
+		Subject:   subject,
+	}).Error
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		d.log().Errorf(err, "cannot add %v login to account %v", provider, account)
+		return err
+	}
+
+	return nil
+}
+
+func (d *Database) ClaimEffects(from, to string) (int, error) {
+	db := d.Model(&Effect{}).Where("owner = ?", from).Update("owner", to)
+	if db.Error != nil {
+		d.log().Errorf(db.Error, "cannot claim effects of %v", from)
+		return 0, db.Error
+	}
+
+	return int(db.RowsAffected), nil
+}
+
+func (d *Database) OwnerEffects(owner string, page, size int) ([]Effect, error) {
+	var effects []Effect
+	db := d.Where("owner = ?", owner).Order("modified desc, id desc").
+		Limit(size).Offset(page * size).Preload("Versions").Find(&effects)
+	err := db.Error
+	if err != nil {
+		d.log().Errorf(err, "cannot retrieve effects of %v", owner)
+		return nil, err
+	}
+
+	return effects, nil
+}
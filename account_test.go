@@ -0,0 +1,269 @@
+package glsl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testAccounts is run by testStore with the effects it creates, owned by
+// owner0 to owner4.
+func testAccounts(t *testing.T, store Store) {
+	require := require.New(t)
+
+	_, err := store.Account("name")
+	require.Equal(ErrNotFound, err)
+
+	a := &Account{Name: "name", Identity: "owner1", Password: "hash"}
+	require.NoError(store.NewAccount(a))
+	require.NotZero(a.ID)
+
+	err = store.NewAccount(&Account{Name: "name", Identity: "other"})
+	require.Equal(ErrConflict, err)
+	err = store.NewAccount(&Account{Name: "other", Identity: "owner1"})
+	require.Equal(ErrConflict, err)
+
+	found, err := store.Account("name")
+	require.NoError(err)
+	require.Equal(a.ID, found.ID)
+	require.Equal("owner1", found.Identity)
+	require.Equal("hash", found.Password)
+
+	found, err = store.AccountByIdentity("owner1")
+	require.NoError(err)
+	require.Equal(a.ID, found.ID)
+	_, err = store.AccountByIdentity("owner0")
+	require.Equal(ErrNotFound, err)
+
+	_, err = store.AccountByLogin("fake", "1")
+	require.Equal(ErrNotFound, err)
+	require.NoError(store.AddLogin(a.ID, "fake", "1"))
+	require.Equal(ErrConflict, store.AddLogin(a.ID, "fake", "1"))
+
+	found, err = store.AccountByLogin("fake", "1")
+	require.NoError(err)
+	require.Equal(a.ID, found.ID)
+	_, err = store.AccountByLogin("other", "1")
+	require.Equal(ErrNotFound, err)
+
+	effects, err := store.OwnerEffects("owner1", 0, 10)
+	require.NoError(err)
+	require.Len(effects, 1)
+	require.Equal(uint(1), effects[0].ParentID)
+
+	n, err := store.ClaimEffects("owner3", "owner1")
+	require.NoError(err)
+	require.Equal(1, n)
+	n, err = store.ClaimEffects("unknown", "owner1")
+	require.NoError(err)
+	require.Equal(0, n)
+
+	effects, err = store.OwnerEffects("owner1", 0, 10)
+	require.NoError(err)
+	require.Len(effects, 2)
+	require.Equal(uint(3), effects[0].ParentID)
+	require.Len(effects[0].Versions, 4)
+	require.Equal(uint(1), effects[1].ParentID)
+
+	effects, err = store.OwnerEffects("owner1", 1, 1)
+	require.NoError(err)
+	require.Len(effects, 1)
+	require.Equal(uint(1), effects[0].ParentID)
+
+	effects, err = store.OwnerEffects("owner3", 0, 10)
+	require.NoError(err)
+	require.Len(effects, 0)
+}
+
+func TestAccountName(t *testing.T) {
+	require := require.New(t)
+
+	for _, name := range []string{"abc", "a-b_c", "0abc", strings.Repeat("a", 32)} {
+		require.NoError(validAccountName(name), name)
+	}
+
+	for _, name := range []string{"", "ab", "Abc", "-abc", "a b", "a/b", strings.Repeat("a", 33)} {
+		require.Error(validAccountName(name), name)
+	}
+
+	tests := map[string]string{
+		"John Smith":            "john-smith",
+		"__x":                   "x__",
+		"ñandú":                 "and-",
+		strings.Repeat("a", 40): strings.Repeat("a", 32),
+	}
+	for name, expected := range tests {
+		require.Equal(expected, accountName(name), name)
+		require.NoError(validAccountName(accountName(name)), name)
+	}
+}
+
+// postForm sends a form as the client with the identity. The CSRF token of
+// the client is added if the values do not have one.
+func postForm(
+	h http.Handler,
+	path, identity string,
+	values url.Values,
+) *httptest.ResponseRecorder {
+	if values == nil {
+		values = url.Values{}
+	}
+	if _, ok := values[csrfField]; !ok {
+		values.Set(csrfField, csrfToken(testSecret, identity))
+	}
+
+	req := httptest.NewRequest("POST", path, strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{
+		Name:  sessionCookie,
+		Value: signSession(testSecret, identity, time.Now()),
+	})
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	return res
+}
+
+// getAs requests the page as the client with the identity.
+func getAs(h http.Handler, path, identity string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	req.AddCookie(&http.Cookie{
+		Name:  sessionCookie,
+		Value: signSession(testSecret, identity, time.Now()),
+	})
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func TestAccounts(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	res := postSave(t, h, saveCode{Code: "0", Image: testImage, User: "anon"})
+	require.Equal("1.0", res.Body.String())
+	res = postSave(t, h, saveCode{Code: "0", Image: testImage, User: "other"})
+	require.Equal("2.0", res.Body.String())
+
+	res = getAs(h, "/login", "id-anon")
+	require.Equal(http.StatusOK, res.Code)
+	require.Contains(res.Body.String(), "Create account")
+	require.Contains(res.Body.String(),
+		`name="csrf" value="`+csrfToken(testSecret, "id-anon")+`"`)
+
+	// invalid accounts are not created
+	res = postForm(h, "/signup", "id-anon", url.Values{
+		"name": {"alice"}, "password": {"short"},
+	})
+	require.Equal(http.StatusUnprocessableEntity, res.Code)
+	require.Contains(res.Body.String(), "password must have")
+
+	res = postForm(h, "/signup", "id-anon", url.Values{
+		"name": {"a"}, "password": {"password"},
+	})
+	require.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	// the effects of the client are claimed on signup
+	res = postForm(h, "/signup", "id-anon", url.Values{
+		"name": {" Alice "}, "password": {"password"}, "claim": {"on"},
+	})
+	require.Equal(http.StatusSeeOther, res.Code)
+	require.Equal("/u/alice", res.Header().Get("Location"))
+
+	alice, err := store.Account("alice")
+	require.NoError(err)
+	require.NotEqual("password", alice.Password)
+	require.Equal(alice.Identity, responseCookies(res)[identityCookie].Value)
+
+	e, err := store.Effect(1)
+	require.NoError(err)
+	require.Equal(alice.Identity, e.Owner)
+	require.Equal("anon", e.User)
+
+	res = postForm(h, "/signup", "id-new", url.Values{
+		"name": {"alice"}, "password": {"password"},
+	})
+	require.Equal(http.StatusConflict, res.Code)
+	require.Contains(res.Body.String(), "name already used")
+
+	res = getAs(h, "/login", alice.Identity)
+	require.Contains(res.Body.String(), "Logged in as alice")
+
+	// the gallery of the user only has its effects
+	res = getAs(h, "/u/alice", "id-new")
+	require.Equal(http.StatusOK, res.Code)
+	require.Contains(res.Body.String(), "Effects by alice")
+	require.Contains(res.Body.String(), "/images/1.png")
+	require.NotContains(res.Body.String(), "/images/2.png")
+	require.Contains(res.Body.String(), "/u/alice?page=1")
+
+	res = getAs(h, "/u/nobody", "id-new")
+	require.Equal(http.StatusNotFound, res.Code)
+
+	// the account owns the effects in any client
+	res = postForm(h, "/login", "id-new", url.Values{
+		"name": {"alice"}, "password": {"wrong password"},
+	})
+	require.Equal(http.StatusUnauthorized, res.Code)
+	require.Contains(res.Body.String(), ErrInvalidLogin.Error())
+
+	res = postForm(h, "/login", "id-new", url.Values{
+		"name": {"nobody"}, "password": {"password"},
+	})
+	require.Equal(http.StatusUnauthorized, res.Code)
+
+	res = postForm(h, "/login", "id-other", url.Values{
+		"name": {"alice"}, "password": {"password"},
+	})
+	require.Equal(http.StatusSeeOther, res.Code)
+	require.Equal(alice.Identity, responseCookies(res)[identityCookie].Value)
+
+	e, err = store.Effect(2)
+	require.NoError(err)
+	require.Equal("id-other", e.Owner)
+
+	res = postForm(h, "/login", alice.Identity, url.Values{
+		"name": {"alice"}, "password": {"password"},
+	})
+	require.Equal(http.StatusSeeOther, res.Code)
+
+	// the effects of other accounts cannot be claimed
+	res = postForm(h, "/signup", alice.Identity, url.Values{
+		"name": {"bob"}, "password": {"password"}, "claim": {"on"},
+	})
+	require.Equal(http.StatusSeeOther, res.Code)
+
+	e, err = store.Effect(1)
+	require.NoError(err)
+	require.Equal(alice.Identity, e.Owner)
+
+	// forms without the token of the client are rejected
+	for _, token := range []string{"", csrfToken(testSecret, "id-other")} {
+		for _, path := range []string{"/login", "/signup", "/logout"} {
+			res = postForm(h, path, alice.Identity, url.Values{
+				"name":     {"alice"},
+				"password": {"password"},
+				csrfField:  {token},
+			})
+			require.Equal(http.StatusForbidden, res.Code, path)
+			require.Equal(alice.Identity,
+				responseCookies(res)[identityCookie].Value, path)
+		}
+	}
+
+	// logging out gives a new anonymous identity
+	res = postForm(h, "/logout", alice.Identity, nil)
+	require.Equal(http.StatusSeeOther, res.Code)
+	id := responseCookies(res)[identityCookie].Value
+	require.NotEmpty(id)
+	require.NotEqual(alice.Identity, id)
+}
@@ -0,0 +1,316 @@
+package glsl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+const (
+	apiPrefix      = "/api/v1"
+	apiMaxPageSize = 100
+)
+
+type apiError struct {
+	Error apiErrorBody `json:"error"`
+}
+
+type apiErrorBody struct {
+	Status  int    `json:"status"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type apiParent struct {
+	ID      uint `json:"id"`
+	Version int  `json:"version"`
+}
+
+type apiVersion struct {
+	Number  int       `json:"number"`
+	Created time.Time `json:"created"`
+	Code    string    `json:"code,omitempty"`
+	Source  string    `json:"source,omitempty"`
+}
+
+type apiEffect struct {
+	ID       uint         `json:"id"`
+	Created  time.Time    `json:"created"`
+	Modified time.Time    `json:"modified"`
+	User     string       `json:"user"`
+	Parent   *apiParent   `json:"parent,omitempty"`
+	Image    string       `json:"image"`
+	Versions []apiVersion `json:"versions"`
+}
+
+type apiTreeNode struct {
+	apiEffect
+	Children []apiTreeNode `json:"children"`
+}
+
+type apiLineage struct {
+	Ancestors []apiEffect `json:"ancestors"`
+	Tree      apiTreeNode `json:"tree"`
+	Truncated bool        `json:"truncated"`
+}
+
+type apiEffects struct {
+	Query   string      `json:"query,omitempty"`
+	Page    int         `json:"page"`
+	Size    int         `json:"size"`
+	Total   int         `json:"total"`
+	Effects []apiEffect `json:"effects"`
+}
+
+func newAPIVersion(v Version, code bool) apiVersion {
+	a := apiVersion{
+		Number:  v.Number,
+		Created: v.Created,
+		Source:  v.Source,
+	}
+	if code {
+		a.Code = v.Code
+	}
+
+	return a
+}
+
+func newAPIEffect(e *Effect) apiEffect {
+	a := apiEffect{
+		ID:       e.ID,
+		Created:  e.Created,
+		Modified: e.Modified,
+		User:     e.User,
+		Image:    fmt.Sprintf("/images/%v.png", e.ID),
+		Versions: make([]apiVersion, 0, len(e.Versions)),
+	}
+
+	// ParentID can be null but uint cannot. Use the default values to detect
+	// orphan effects.
+	if e.ParentID != 0 || e.ParentVersion != 0 {
+		a.Parent = &apiParent{
+			ID:      e.ParentID,
+			Version: e.ParentVersion,
+		}
+	}
+
+	for _, v := range e.Versions {
+		a.Versions = append(a.Versions, newAPIVersion(v, false))
+	}
+
+	return a
+}
+
+func newAPITreeNode(n *LineageNode) apiTreeNode {
+	a := apiTreeNode{
+		apiEffect: newAPIEffect(&n.Effect),
+		Children:  make([]apiTreeNode, 0, len(n.Children)),
+	}
+
+	for _, c := range n.Children {
+		a.Children = append(a.Children, newAPITreeNode(c))
+	}
+
+	return a
+}
+
+func (s *Server) api() http.Handler {
+	r := chi.NewRouter()
+	r.Use(compress)
+
+	r.Get("/effects", s.apiEffects)
+	r.With(s.identify).Post("/effects", s.apiSave)
+	r.Get("/effects/{id:[0-9]+}", s.apiEffect)
+	r.Get("/effects/{id:[0-9]+}/versions/{version:[0-9]+}", s.apiVersion)
+	r.Get("/effects/{id:[0-9]+}/tree", s.apiTree)
+
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		apiErrorf(w, http.StatusNotFound, "unknown endpoint %v", r.URL.Path)
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		apiErrorf(w, http.StatusMethodNotAllowed,
+			"method %v not allowed", r.Method)
+	})
+
+	return r
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	m, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf(err, "cannot marshal response")
+		status = http.StatusInternalServerError
+		m, _ = json.Marshal(apiError{Error: apiErrorBody{
+			Status:  status,
+			Message: http.StatusText(status),
+		}})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(m)
+	if err != nil {
+		log.Errorf(err, "cannot write response")
+	}
+}
+
+func apiErrorf(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, apiError{Error: apiErrorBody{
+		Status:  status,
+		Message: fmt.Sprintf(format, args...),
+	}})
+}
+
+// apiInternalError sends a generic error so internal details are not leaked.
+func apiInternalError(w http.ResponseWriter) {
+	apiErrorf(w, http.StatusInternalServerError,
+		http.StatusText(http.StatusInternalServerError))
+}
+
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	text := r.URL.Query().Get(name)
+	if text == "" {
+		return def, nil
+	}
+
+	return strconv.Atoi(text)
+}
+
+func (s *Server) apiEffects(w http.ResponseWriter, r *http.Request) {
+	db := s.store(r)
+
+	page, err := queryInt(r, "page", 0)
+	if err != nil || page < 0 {
+		apiErrorf(w, http.StatusBadRequest, "invalid page")
+		return
+	}
+
+	size, err := queryInt(r, "size", perPage)
+	if err != nil || size < 1 || size > apiMaxPageSize {
+		apiErrorf(w, http.StatusBadRequest,
+			"invalid size, it must be between 1 and %v", apiMaxPageSize)
+		return
+	}
+
+	var (
+		total   int
+		effects []Effect
+	)
+	query := r.URL.Query().Get("q")
+	if query != "" {
+		effects, total, err = db.Search(query, page, size)
+	} else {
+		total, err = db.EffectCount()
+		if err == nil {
+			effects, err = db.Effects(page, size)
+		}
+	}
+	if err != nil {
+		apiInternalError(w)
+		return
+	}
+
+	res := apiEffects{
+		Query:   query,
+		Page:    page,
+		Size:    size,
+		Total:   total,
+		Effects: make([]apiEffect, 0, len(effects)),
+	}
+	for i := range effects {
+		res.Effects = append(res.Effects, newAPIEffect(&effects[i]))
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// apiLoadEffect retrieves the effect from the id URL parameter. It writes the
+// error response and returns nil when it cannot be loaded.
+func (s *Server) apiLoadEffect(w http.ResponseWriter, r *http.Request) *Effect {
+	db := s.store(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		apiErrorf(w, http.StatusBadRequest, "invalid effect id")
+		return nil
+	}
+
+	effect, err := db.Effect(id)
+	if err == ErrNotFound {
+		apiErrorf(w, http.StatusNotFound, "effect %v not found", id)
+		return nil
+	}
+	if err != nil {
+		apiInternalError(w)
+		return nil
+	}
+
+	return effect
+}
+
+func (s *Server) apiEffect(w http.ResponseWriter, r *http.Request) {
+	effect := s.apiLoadEffect(w, r)
+	if effect == nil {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIEffect(effect))
+}
+
+func (s *Server) apiVersion(w http.ResponseWriter, r *http.Request) {
+	effect := s.apiLoadEffect(w, r)
+	if effect == nil {
+		return
+	}
+
+	number, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil || number < 0 || number >= len(effect.Versions) {
+		apiErrorf(w, http.StatusNotFound, "version %v of effect %v not found",
+			chi.URLParam(r, "version"), effect.ID)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAPIVersion(effect.Versions[number], true))
+}
+
+func (s *Server) apiTree(w http.ResponseWriter, r *http.Request) {
+	db := s.store(r)
+
+	effect := s.apiLoadEffect(w, r)
+	if effect == nil {
+		return
+	}
+
+	lineage, err := LoadLineage(db, int(effect.ID))
+	if err != nil {
+		apiInternalError(w)
+		return
+	}
+
+	res := apiLineage{
+		Ancestors: make([]apiEffect, 0, len(lineage.Ancestors)),
+		Tree:      newAPITreeNode(lineage.Tree),
+		Truncated: lineage.Truncated,
+	}
+	for i := range lineage.Ancestors {
+		res.Ancestors = append(res.Ancestors, newAPIEffect(&lineage.Ancestors[i]))
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) apiSave(w http.ResponseWriter, r *http.Request) {
+	effect, _, err := s.saveRequest(r)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%v/effects/%v", apiPrefix, effect.ID))
+	writeJSON(w, http.StatusCreated, newAPIEffect(effect))
+}
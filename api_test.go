@@ -0,0 +1,154 @@
+package glsl
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func apiRequest(
+	t *testing.T,
+	h http.Handler,
+	method, path string,
+	body []byte,
+	res interface{},
+) int {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	if res != nil {
+		err := json.Unmarshal(rec.Body.Bytes(), res)
+		require.NoError(t, err, rec.Body.String())
+	}
+
+	return rec.Code
+}
+
+func TestAPIEffects(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	for i := 0; i < 5; i++ {
+		e, err := store.NewEffect(0, 0, "user", "")
+		require.NoError(err)
+		_, err = store.AddVersion(e, "code")
+		require.NoError(err)
+	}
+
+	var effects apiEffects
+	status := apiRequest(t, h, "GET", "/api/v1/effects?page=1&size=2", nil, &effects)
+	require.Equal(http.StatusOK, status)
+	require.Equal(1, effects.Page)
+	require.Equal(2, effects.Size)
+	require.Equal(5, effects.Total)
+	require.Len(effects.Effects, 2)
+	require.Equal(uint(3), effects.Effects[0].ID)
+	require.Equal(uint(2), effects.Effects[1].ID)
+	require.Len(effects.Effects[0].Versions, 1)
+	require.Empty(effects.Effects[0].Versions[0].Code)
+
+	var apiErr apiError
+	status = apiRequest(t, h, "GET", "/api/v1/effects?size=1000", nil, &apiErr)
+	require.Equal(http.StatusBadRequest, status)
+	require.Equal(http.StatusBadRequest, apiErr.Error.Status)
+	require.NotEmpty(apiErr.Error.Message)
+
+	status = apiRequest(t, h, "GET", "/api/v1/effects?page=a", nil, &apiErr)
+	require.Equal(http.StatusBadRequest, status)
+}
+
+func TestAPIEffect(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	store.Add(&Effect{
+		ID:            10,
+		ParentID:      5,
+		ParentVersion: 2,
+		User:          "user",
+		Versions: []Version{
+			{Number: 0, Code: "first"},
+			{Number: 1, Code: "second"},
+		},
+	})
+
+	var effect apiEffect
+	status := apiRequest(t, h, "GET", "/api/v1/effects/10", nil, &effect)
+	require.Equal(http.StatusOK, status)
+	require.Equal(uint(10), effect.ID)
+	require.Equal("user", effect.User)
+	require.Equal(&apiParent{ID: 5, Version: 2}, effect.Parent)
+	require.Equal("/images/10.png", effect.Image)
+	require.Len(effect.Versions, 2)
+	require.Equal(1, effect.Versions[1].Number)
+
+	var version apiVersion
+	status = apiRequest(t, h, "GET", "/api/v1/effects/10/versions/1", nil, &version)
+	require.Equal(http.StatusOK, status)
+	require.Equal(1, version.Number)
+	require.Equal("second", version.Code)
+
+	var apiErr apiError
+	status = apiRequest(t, h, "GET", "/api/v1/effects/10/versions/2", nil, &apiErr)
+	require.Equal(http.StatusNotFound, status)
+	require.Equal(http.StatusNotFound, apiErr.Error.Status)
+
+	status = apiRequest(t, h, "GET", "/api/v1/effects/11", nil, &apiErr)
+	require.Equal(http.StatusNotFound, status)
+
+	status = apiRequest(t, h, "GET", "/api/v1/unknown", nil, &apiErr)
+	require.Equal(http.StatusNotFound, status)
+}
+
+func TestAPISave(t *testing.T) {
+	require := require.New(t)
+
+	server, _, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	body, err := json.Marshal(saveCode{
+		Code:  "code",
+		Image: testImage,
+		User:  "user",
+	})
+	require.NoError(err)
+
+	var effect apiEffect
+	status := apiRequest(t, h, "POST", "/api/v1/effects", body, &effect)
+	require.Equal(http.StatusCreated, status)
+	require.Equal(uint(1), effect.ID)
+	require.Nil(effect.Parent)
+	require.Len(effect.Versions, 1)
+
+	body, err = json.Marshal(saveCode{
+		CodeID: "1.0",
+		Code:   "fork",
+		Image:  testImage,
+		User:   "other",
+	})
+	require.NoError(err)
+
+	status = apiRequest(t, h, "POST", "/api/v1/effects", body, &effect)
+	require.Equal(http.StatusCreated, status)
+	require.Equal(uint(2), effect.ID)
+	require.Equal(&apiParent{ID: 1, Version: 0}, effect.Parent)
+
+	var apiErr apiError
+	status = apiRequest(t, h, "POST", "/api/v1/effects", []byte("{"), &apiErr)
+	require.Equal(http.StatusBadRequest, status)
+}
@@ -448,27 +448,30 @@ Fao05AXbVESYzrT3K1LK2T6uyA7z6azmp2UPOuoH2A866n9M8H8DAAD//2wiOE2pYAAA
 	"/assets/gallery.html": {
 		name:    "gallery.html",
 		local:   "assets/gallery.html",
-		size:    2465,
-		modtime: 1567167345,
+		size:    2854,
+		modtime: 1792302000,
 		compressed: `
-H4sIAAAAAAAC/5RWbW/bNhf9bP2KW+XB4xawJUtxs1SRVHeN1xYotgLthg3DPlDitUREIlWScuwJ/u8D
-9eIoaTJ3CSxdk/ecc1/IC4fPrn95++WPT2vIdVnEVmheUBCeRTZyO7YmYY6ExtZkEmqmC4zfffz8ET4T
-ThOxg3ekKFDuQ7fbM14lagJpTqRCHdm13swv7buNXOtqjl9rto3s3+e/vpm/FWVFNEsKtCEVXCPXkf1h
-Ha1phrM0l6LEyOsIlN53GpNE0D00xpokJL3JpKg5naeiEDKAs0X7d9VubwTXAXjn1Q6+kFyUZAZvJCPF
-DN5jsUXNUjIDRbiaK5Rs04FKIjPGAzjHEpZYdosD++XlZbtwMA/S3NsjhHTOGnd6TjEVkmgmeABccOy2
-EiEpynkitBZlAF61AyUKRuFsuVyOmINcbFHe518sXl2vX53iGXkdLPPMvRnkHpAZ5P7ThF2p/Mv/VCoD
-mt8iy3JtkpQlKcY1PIb3Q7W7y22IogUr9jcG4Pu9wwDUogrA74vfos6y7rA137gtH3OL+950dRp14J4X
-sDK7Ryi7VAblh3kc128Z1XkA/mIxBJ73VfDulgbtUXN8z/w/Hsi45YnYzVVOqLgNYFHt2o+/rHbgGevM
-vzi/vnj5pMq5d/HTm/WdSkESLDpiylRVkH0ASSHSm29r7jnLJ3JfOBfDzqhz3nLI9pFD1YozXtV6BuZO
-EInk4b0N4Mz3/e/gTdP06vGWTipCKeNZAC/7CnXLotYF4zj2TGupDFslGNcoH0T5p95XGKk6KZn+68QN
-NKjQPQ6lUKWSVTre1Dw1dx7+95zRWfmioSKtS+TayVCvCzTmj/sP9DmjL5wW7fQtiaB8PW27Mg2mJuLp
-1SF0e1prErr9JA7N+IstK6RsC4xGtllHaZvp7cUhgVziJrLNqA1cNytUobpx7aSidO17Ezx0SRy6uRdb
-R5yLdvxWItEIHG8BNxtM9TPjCP/niaquuqfbvax7esoIMp3XSatVSipE0oYw72Ow427/Sb7hPiR7eJiK
-vmVao2ypdY5bcmvHq85o6Qin/4rZIFPKjlftexRALw1ImRbylHKXlB2vOsPwzE4AbpjKWYtordOQauHZ
-8apaeKddSaFRIv1qx6vBPA26qVVJEqbRjldH+7tqiCVJb3Bvx6veMigrdCnbjo9k30U7tiyAppGEZwjO
-uj1L6nCw4CgydfGsaZwP14eD0zTOR6L0bygVE/xwmMYhKzNQMo2mLitJhsodfCueTeNW3Aggp4eD9W0c
-FckYJybP1g3YBpz3RH2SuGWiVp9IhvAgnNcVyTBqGmfsZGIZvoNx6JXHJ7hTQE4N4+OUP+NOD3TGvqM6
-Ru52tzt0ux9k/wQAAP//MHVuW6EJAAA=
+H4sIAAAAAAAC/5RWf2/bNhP+O/oUV+ZF3QK2ZTlu3lSR2ORt8rYFii1Du2HDsD8o8SwRkUiFpBx7gr77
+QMm/kiZ1l8DSmbznuYd3vIOjF1c/v//6x8015LYsqBe5FxRMZjFBSah3FOXIOPWOjiIrbIH0w+cvn+EL
+kzxRS/jAigL1KvL7PedVomWQ5kwbtDGp7Xx0RnYbubXVCO9qsYjJ76NfL0fvVVkxK5ICCaRKWpQ2Jp+u
+42ue4TDNtSoxDnoCY1d9jKNE8RU0zjpKWHqbaVVLPkpVoXQIx5Pu77zbnitpQwhOqiV8Zbkq2RAutWDF
+ED5isUArUjYEw6QZGdRi3oNKpjMhQzjBEmZY9osb9rOzs26hdQ/WPNhjjPXOFpd2xDFVmlmhZAhSSey3
+EqU56lGirFVlCEG1BKMKweF4NpvtMYe5WqB+yD+ZvL26fnuIZ8+r9dwzD4aQB8CGkE+fJ+xTNT37V6ly
+oNE9iiy37pC6ZMV+Drfy/lstd2fbqOjARvyNIUyn1fIB0KoqhCmWO9Rx1l+25hu32VNulDV7edqrwAMv
+EGX2gFD3R9lEfnyO7fq94DYPYTqZbITn6ywEu6VN7L3iTAP3/7SQ/ZInajkyOePqPoRJtew+01m1hMBZ
+x9PTk6vTN89GOQlO/395vYtSsASLnpgLUxVsFUJSqPT225wH49kzZ5+MTzc7e5ULZpvTPnGpuuBCVrUd
+gusJppE97tsQjqfT6Q/wpml6/nRJjyrGuZBZCG/WGeqXVW0LIXHfM621cWyVEtKifqTyT7uqMDZ1Ugr7
+14EOdKjI3w6lyKRaVJbOa5m6nof/vBJ8WL5uuErrEqUdZ2ivC3Tm/1af+CvBX4879HhdkhjKd4OuKoNw
+4BQPztvIX9N6R5G/nsSRG3/U8yIuFiB4TNw6auKmd0AjBrnGeUzcqA19PytMYfpxPU5V6ZMHEzzyGY38
+PKDeFucjoe81Mosg8R5wPsfUvnCO8FImpjrvn37/2sMVKhOSUJamqpb2MMAJNE6hsHmddOJKzZVKOs2j
+tWhC+/1n+TYNlKzg8dntvbAWdUdtc1ywe0IveqOjY5J/FzNHYQyhF917T8A6NCAXVulDkftDEXrRG45n
+eABwK0wuOkRnHYZUk4DQi2oSHHZlhUWN/I7Qi415GHRbm5IlwiKhF1v7h3KIJUtvcUXoxdpyKC/yuVi4
+OzxXuuwusUGm05wA67onJj6BEm2ueEwytO5yd0MM5krH5I7QL50/pIojKA21QR35nQv1oq6ZoWtm4uYO
+6ULcEZCsxM5YsKLGmDTN+Jca9aptySNYPwS2jn045+Q7ydTzmkbMYXzZ3/a29aJ8Sq+7ZjHuRjTNbi/y
+8yn1mgYlb9u9xl1fXUI9D6BpNJMZwnhN0rYebDM78PG4acafrtp23DTjz8zY31AboWTbDmgkygyMTuOB
+L0qWofE3vpXM3rl5Gq9DDWiXfoCdmE0lNqIqlgnJXKU7N3Cn/MjMjcaFULW5YRnCQ21NM75hNm/bdxXL
+MHZf95zbts/UOs8v7+Jd0tcqBnQDAMewlrjf7L0UlNyF/n7sn3Bpfziuc97F3ObC70ds5Pe/iv8ZAJrl
+IComCwAA
 `,
 	},
 
@@ -1141,32 +1144,35 @@ LM9no/8GAAD//4t5QMdyGQAA
 	"/assets/js/helpers.js": {
 		name:    "helpers.js",
 		local:   "assets/js/helpers.js",
-		size:    3896,
-		modtime: 1567167345,
+		size:    4347,
+		modtime: 1792301740,
 		compressed: `
-H4sIAAAAAAAC/5RXT2/buBI/S59imj6U8osrO+/wDjF0aV6B10V3u2i620MRGLQ4kolSpEFSTtxuvvti
-SPmPZCfxBjlI4m+Gv/k/TtfcguNrfNd6b/QYKmO/b59X3KL22zchqyo+z4IUVhWWfm7uNdqi4sph/G6s
-rKXmal4agQVjg69rtE4aHQ7SqtWll0aD1NJLruQPnJemWVl0zths9DNNLPrWatCtUrP08bTIEtUKbTaC
-n2lyL7Uw97nRS+6W5ZLrGqGArRRhQBku5q2NDLPRDB5naZrICjK/WaGpQJmSq1tvLK8RXhUFsFYLrKRG
-wcIlAQyvDnF5jf6DxyZjtXLKcS0W5mHeOrRsBFEo6eHdk/gx1KjRco/hfS5FNhrN0iR5TJNHQOUwqJtM
-4MtSOqi4Ugtefge3NK0SmnlYILQOBSw2wPUGFtbcO7QO/JJ74BaBLxSCN1CappEeyBF52icIBfyEzqjr
-vQMfyINdUJjUa66kiLRn8EieTB57YTo2BfZRzX7lfplbroVpstG/pw9X0/j313SUe3PrrdR1dvXf0Wyg
-059Ud248+tp4M5cxjfvcDhMc3rzpJ3zR4zDQOEgwUhoSpstNokm4nFL0VcFCTgXvcxFFTgFz1y6ct9kV
-3XaQCGmShPA59H9y1WImTNk2qD154L1Ceny3+SAyYPjAm5VCBqPc44MPOdUrVyjgbOlAou9IIeYO7Rrt
-fBEahYu27/vLof7SIvfYXZEBiyIMiNVeInd+ozBfSycXUkm/gQLYUgqBmvWBxOnGaI/aE4ZOBgguxPs1
-av9ROk9ZmQErlSy/s3FogWMIXSwQ8MaoBbc5X61Qi5ulVCI76JMQQnDYH5+zjEejDuEvmNWDDgyLZ0eo
-pcWKjifSYzNhcHnUcp8yq2dGMGzf6s8waw9+wagD4MAkOhkgduawp2gfkAykHfp5NKXLvowFJgqHBU9I
-iuUWtyfclWrWn2FFqK96W1+jUZqcn6FUpS/i9y/HTPs2PcN1O1m7hpL8k4Q7P4oHA+gl/YdWvXRDDxva
-Sr/dy6am/in8cgxLlPXSx6Facr3mLg8nUETEbPe5QxadSKwZ3qBH63JXWkT9dSB5BPj/UEea1CpfS7xf
-GeszmI7pf0CNKIRy+YxaoP3CbY3eZfTdokN/29qKl7R8pPSFMPFZNnXRkffmf9zzPz5/zJhseI2Tla5Z
-ABn9NQyIz+jkj52SMLVkUw9SiK+3I6ipi60r/zOdjuFqOo3lzj0vKKAXlOgX1zDI9zEdBQoX13RBeKfZ
-d3HdH8UUuZSWiIJNqPmG/Dwcr1Q5dNu3cNNciou74vlZ11VQyLcoGbPuHEEi8698ZZzPlCmJ9S+3n37L
-XdgqZLXJSGGwbrfdWHSt8t26NrzA4kpR0NgEX7PLDjqLi11/o6RdbQwX1OYuTu0GAUZ8d7tBreKltP1K
-tZtp0isayizUaP8sFlFplLGEeF1VVTWdngAOmu1Hw4XUdZ7noZxj4zxsh9tiD4P4uKnuT8m7NXpyarad
-O5dk1RhOOrSj9UnfhLX8Ji4c8acDwGQCv1uk+QwdELxs0ILz3PqjLScq/sboK7s7XmWGTZvoUjpu5box
-etfF+qiLDnEhzqcnLr5+YtwmpyYafTopAJfA3q7dWzojLwYFz0+15LAdJyd/Yz3Jmj1FsDt5NvRJKK6k
-txJvPRZ27Lutv4flf8bKtp2Zz0DpZ2poMEftdJdmvZdBznnbIiV/rM3JBETbNJtd1rr+CKYWXFle095D
-6fKYpn8HAAD//w1HND84DwAA
+H4sIAAAAAAAC/5RXbY/buBH+LP2KyaY4yolPdoCiKNYQDrg0QK9ImyJJex/SPYMWRxKxFGmQtHd9G//3
+YkjZlmTvyy0WuxL5cDjv8yjdcguOb/HnjfdGT6Ey9vbwvOYWtT+8CVlV8XkRTmFVYemX5k6jLSquHMZ1
+Y2UtNVfL0ggsGButbtE6aXTYSKuNLr00GqSWXnIlf8dladq1ReeMzSYPaWLRb6wGvVFqke4vH2lQrdFm
+E3hIkzuphbnLjW64a8qG6xqhgMMpwoAyXCw3NmqYTRawX6RpIivI/G6NpgJlSq6+eGN5jfCqKIBttMBK
+ahQsXBLA8KqPy2v0v3hsM1YrpxzXYmXulxuHlk0gHkoGePcofgo1arTcY3hfSpFNJos0SfZpsgdUDoO4
+2Qy+NtJBxZVa8fIWXGM2SmjmYYWwcShgtQOud7Cy5s6hdeAb7oFbBL5SCN5AadpWeiBH5OlQQSjgATqj
+rk8OvCcPdkFhUm+5kiKqvYA9eTLZD8J0bgqcopr9k/smt1wL02aTN/P7d/P4830+yb354q3UdfbuL5PF
+SKa/KO6l8QjSZrMgRwrUXvpdZxP5COG4WMstanIjrTq0W7Q5fNIqLsQScCSqtMg9CriTvgHyKNcUBSot
+MQ1g4xsKAXmfigxFPjTocGe0iGqm5b5soABhyk2L2uelMbcS87CezbKfrn/7vvifezMhC5dSFNm33xY3
+byYzSpbOJVHGT/H/t3c3cH2hlHi7lLGQh9Hplzj88MOw5Iuh0qMQjWqMpIaa6cqTIkW4nKr0VcFCWYUE
+5CIeuQTM3WblvM3e0W29WkiTJGSwQ/9frjaYHT1Wo/+gkB5/3v0iMmB4z9u1QgaT3OO9D2U16Fh9fz93
+Oigx9KQQy5gmy1XolS7afmqxg3iGpOmuyIDFIwwmi/6J3PmdwnwrnVxJRVlZAGukEKjZEEg6vTfao/aE
+oZ0RggvxYYvaf5TOU2FmwEoly1s2Dak6hdDIgwLeGLXiNufrNWrxvpFKZL1RASEE/RHxlGU8GtWHP2PW
+ADoyLO6doRqLFW3PpMd2xuDt2dR5zKyBGcGw07R7gVkn8DNG9YAjk2hnhDiawx5Tu6dkUNqhX0ZTuuzL
+WNBEIRuVJyEplgfcSeGuVLPhGC/ob14f6msySZOXZyhV6bP408u5pkObntD1QC66hpL8kYR7eRR7M/g5
++X2rnrthgA1tZTQg2pr6p/DNFBqUdeMjryi53nKXhx0oImJxXO6QRXck1gxv0aN1uSstov51dPIM8Pex
+jDSpVb6VeLc21mcwn9LvSDVSIZTLZ9QC7Vdua/Qui7PJof+ysRUviX+ltEKY+CzbuuiU9+Zv3PP/fP6Y
+MdnyGmdrXbMAMvrXMCA+o5O/H4WEsSXbepRCfHsYQW1dHFz51/l8Cn+ez2O5c88LCugVJfrVNYzyfUpb
+QYWra7ogvBOZuLoeshGKXEo8qmAzar4hP/vzlSqHbvsWblpKcXVTPD3rugqCh+PJmHUvOUjK/ClfG+cz
+ZUrS+h9fPv0rd4FYyWqXkcBg3ZHgWXQb5TvGOr7A4lpR0NgMX7O3HXQRue2QVBNdncIVtbkrsjmvuFTZ
+iUY2trsiMB10LlJOVhKHBW18CFuPZoUaTbzdxWPJ6Uwwac2tQxKbW3Rrox1+pfmeo7XG5h04iNhDGUhR
+hhN42NMKV2h9xj4Q9BpocHT4aMYlahOsJHcfqU2tokH0/SLVcSRLr4JdbHG2F3tAaZSxhHhdVVU1n18A
+jmbFR8OF1HWe56Ebxb7f7+aHXhV4xPlMOO1SctToyYHZYWy+JaumcDEfOrU+6ffhw+p95Evx4w9gNoN/
+WyR6AR0QvGzRgvPc+jOSFgV/Y7TKbs6Z2HjmkLpUTYdzHQu46fLobAiMcSH4lwkDvn6ELSSXBjItXTwA
+b4H9uHU/0h55MQh4eign/WmSXPxKflRr9piCM/bYzb3ECL0hGVD6g8fCG7s5OHzcvl5AOQ8z/wkofQSF
+Bnk2Do55NngZJZ23Gyrn/fFjTmzadndMWzekEDRCKstr4m2UL/s0/f8AWOfEgPsQAAA=
 `,
 	},
 
@@ -1747,6 +1753,56 @@ H4sIAAAAAAAC/+ICBAAA//+TBtcyAQAAAA==
 `,
 	},
 
+	"/assets/login.html": {
+		name:    "login.html",
+		local:   "assets/login.html",
+		size:    2682,
+		modtime: 1792305113,
+		compressed: `
+H4sIAAAAAAAC/8xWTW/jNhA9x79ilgF6ii1b67aOVlKxyKbtIWiDZi9F0QMljiQiEimQlD8q+L8XpCRb
+dryb3WIPzcFWOPNm3gzfjBW++fD73cc/H++hMFUZT0L7BSUVeURQkHhyFRZIWTy5ugoNNyXGvzw8PcAT
+FSyRW3ifprIRJvQ6m/Wq0FBIC6o0mog0JpuuiDNos+tcrhLJdtDap6uEps+5ko1g01SWUgVwPXd/75w5
+k8IEsHhbb+EjLWRFb+C94rS8gV+xXKPhKb0BTYWealQ860AVVTkXAbzFCpZYdYdD9NVq5Q729oO2JzZK
+aedscGumDFOpqOFSBCCkwM6USMVQTRNpjKwCWNRb0LLkDK6Xy+UoclDINarT+PP57Yf729fijLxcqGJx
+A8UC6A0U/qfjdZ3yV1/VKQuabpDnhbE1qoqW4xYe2P1Yb0d8/PYI1vwfDMD36+0J0Mg6AB+rI6qkCZYd
+kHFdl3QXQFLK9PklbjE73NoZj/nsh8Eyyr5YDtkvNMYl56JuzLngArj2ff8LoqVpOr6zsRpqyhgXeQDf
+11tYzAekbEzJBY48jzT+MrsaI90kFTd/t58rPm2UtgRqyYVB9bk4r6jNoa5RKanaT17TAYi3y2w+PwBD
+b5jc0Ot3QWgnOJ5MQsbXwFlE7DkqYvfHIg4pFAqziHjkZFuEHo1Dr1jEk7FHTssS1c4a4TuR6Ppd9+l1
+XyNfJPGdQmoQBG4AswxT88biJqHH+DqeTNqWZzC7t3Xu90d2rnASt+1g6v3bFgXb7wdcv8zsQVj48YPM
+c2TABVANbTuYZ7/RCm2Iwh8X0ngvXEi8k43qieqOaCZVBTS1WyUiXilz2RgCFZpCsojUUhvbRHfB4C6Y
+FJwxFAQErTAiqVYZgTUtG4xI287unv74eb8/x3SqOPg9yBxsHtsoS8B1CkuNo1KBi76kFxS5+DYM3QqA
+TKqIuKhTCyCxbVboOeNZYLuGibvBkX+fpns+pulbDrQxMpVVXaLBiDQaVZflQvqaar2RipH4sX+6TOPg
+N6JyPOvoHP8/JZA2SqEwo1w9kfgkRVpg+pzI7aGJJeUVAXeMLAbKGJgCBy1B6ubAidMUXEOi5Eajusz/
+ghq4OBWDoiJHmD0queYMlbbTU48G2RVtFb7f/+S4RQvSqwY23BTgTN2A16PBsuLqZ5YOrwoXRKZ5Lpr6
+m6usC/sVMhsDTnT2Rarq0f9NVufgV3QlcPN/0tTpHZ8tml4KXvejEXrdm+a/AwBkzbpKegoAAA==
+`,
+	},
+
+	"/assets/tree.html": {
+		name:    "tree.html",
+		local:   "assets/tree.html",
+		size:    2077,
+		modtime: 1792300248,
+		compressed: `
+H4sIAAAAAAAC/5RW72/bNhD9bP0VVwVYvliW5WRdqkocgsTdCgRYgWbD9pEWTxIRitRI2nEm6H8fqB8x
+vbYbZsMSxXv33t0TKTl7c//L3eMfn7ZQ20aQIHMnEFRWeYgyJMEiq5EyEiwWmeVWIPnp4fMDfKaS7dQR
+Pij9BI8aMYvHqMM1aCkUNdUGbR7ubRndhKdAbW0b4Z97fsjD36Nfb6M71bTU8p3AEAolLUqbhx+3+ZZV
+uCxqrRrMk5HA2JdRY7FT7AU6N1rsaPFUabWXLCqUUDqFi/XweT+ESyVtCslVe4RHWquGLuFWcyqW8DOK
+A1pe0CUYKk1kUPNyTGqorrhM4QobuMZmnJzZb25uhoneHWh3FqOUjmCLRxsxLJSmliuZglQSx9BOaYY6
+2ilrVZNC0h7BKMEZXFxfX3vMaa0OqM/51+t399t3/8XjofrAHetkCXUCdAn15tuEo1Wbm/9llUuKnpFX
+tXVN6oYK38PX8n5oj6fe5iqGZMP/whQ2m/Z4lmhVm8IGm1PWXoxZghsbDWvBt7WljHFZRQJLe0qcXRpn
+PY82ifueyAXvvlBPfPUVliUWltDO4/UK8EHAm2qEHVA730REBa9kCg1nTOBZo3r0bhZbPHNm6xQ26/Xs
+SD3Zm5ymZvl/6cirxV9KO3WMTE2Zek5h3R6H3+a6PULiRhebt1f3b7//pshV8vbD7dYTKfZao7REcPJF
+81/JP1uai0UWz3s6i6cHTeb2NgmCjPEDcJaHbh516B5OCcko1BrLPIzDs0dRFlOSxXVCghMCQ3KnkVoE
+ic8wFvfGAeE7uTPt+/EYj6fAZ66oEKhfHDbIYsYPJAi6jmHJJUI4MoV9H2SmpRIKQY3J52kSAMxclzFe
+dN3q433fr7pu9UCN/Q214Ur2/SXJeFOB0UV+GfOGVmjiGdvK6ke3LfKpkEsylOITW4044y/JNJhQY0dd
+J1DCahI0fQ+HaRgAdB0vYfWJunvnMs+8KJV+QgalVs2cA103oV8b6DqUzJkQOxdIMF97TknFcPBJcDKI
+Wmxa4e7I7BastsOg71+Luqu5YBrlMJXthct0MU1lhX7YpxuUYHWqCiCLx9xTna4Kr0ondisLNFZp4wD1
+hrxeZ3G9IUE2U0ziPhzAtfXVnvp+FPPFHdOruJNyL8+TzLyKpv0U/tOvqUH3svXoXAuPei8LanGQacmj
+UtBQ+QLuLpolKClewKgGQZVga2yAagRTq2e5yuLWLyoet14Wj38G/h4ANr1CSB0IAAA=
+`,
+	},
+
 	"/assets": {
 		name:  "assets",
 		local: `assets`,
@@ -1774,6 +1830,8 @@ var _escDirs = map[string][]os.FileInfo{
 		_escData["/assets/editor.html"],
 		_escData["/assets/gallery.html"],
 		_escData["/assets/js"],
+		_escData["/assets/login.html"],
+		_escData["/assets/tree.html"],
 	},
 
 	"assets/css": {
@@ -29,8 +29,15 @@ function get_user_id() {
 	return localStorage.getItem('glslsandbox_user');
 }
 
+// get_identity returns the identity given by the server. Only the effects
+// created with it can be saved, the others are forked.
+function get_identity() {
+	var match = document.cookie.match(/(?:^|;\s*)glsl_id=([^;]*)/);
+	return match ? match[1] : null;
+}
+
 function am_i_owner() {
-	return (effect_owner && effect_owner==get_user_id());
+	return (effect_owner && effect_owner==get_identity());
 }
 
 function load_url_code() {
@@ -106,7 +113,7 @@ function get_img( width, height ) {
 }
 
 function save() {
-	img=get_img(200, 100);
+	img=get_img(800, 400);
 
 	data={
 		"code": code.getValue(),
@@ -127,7 +134,14 @@ function save() {
 		function(result) {
 			window.location.replace('/e#'+result);
 			load_url_code();
-		}, "text");
+		}, "text")
+		.fail(function(xhr) {
+			var message = 'could not save the effect';
+			try {
+				message = JSON.parse(xhr.responseText).error.message;
+			} catch (e) {}
+			alert('Error: ' + message);
+		});
 }
 
 function load_code(hash) {
@@ -156,7 +170,7 @@ function load_code(hash) {
 			set_parent_button('hidden');
 		}
 
-		effect_owner=result['user'];
+		effect_owner=result['owner'];
 
 		if(am_i_owner())
 			saveButton.textContent = 'save';
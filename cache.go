@@ -0,0 +1,177 @@
+package glsl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// assetCacheControl lets clients reuse assets for a while before
+	// revalidating them with their ETag.
+	assetCacheControl = "public, max-age=3600"
+	// imageCacheControl is shorter than the assets one as images change
+	// each time the owner saves a new version.
+	imageCacheControl = "public, max-age=300"
+	// revalidateCacheControl makes clients check the ETag each time.
+	revalidateCacheControl = "no-cache"
+	// immutableCacheControl is used for content that never changes.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+)
+
+// contentETag returns a strong ETag built from the hash of the content.
+func contentETag(data []byte) string {
+	h := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(h[:16]) + `"`
+}
+
+// versionETag returns the ETag of a version of an effect. Versions never
+// change so the number identifies its content.
+func versionETag(effect, version int) string {
+	return fmt.Sprintf(`"%v.%v"`, effect, version)
+}
+
+// encodedETag returns the ETag of the content compressed with the encoding.
+// Each representation needs a different strong ETag.
+func encodedETag(etag, encoding string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// etagMatches checks if any of the ETags in an If-None-Match header matches
+// the given one.
+func etagMatches(header, etag string) bool {
+	for _, e := range strings.Split(header, ",") {
+		e = strings.TrimPrefix(strings.TrimSpace(e), "W/")
+		if e == "*" || e == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// assetETags caches the ETags of the assets so they are hashed only once.
+// The cached values are discarded if the size or modification time of the
+// file changes, as it happens when serving local files during development.
+type assetETags struct {
+	m     sync.Mutex
+	etags map[string]assetETag
+}
+
+type assetETag struct {
+	size    int64
+	modtime time.Time
+	etag    string
+}
+
+func (a *assetETags) get(name string, f http.File, stat os.FileInfo) (string, error) {
+	a.m.Lock()
+	cached, ok := a.etags[name]
+	a.m.Unlock()
+
+	if ok && cached.size == stat.Size() && cached.modtime.Equal(stat.ModTime()) {
+		return cached.etag, nil
+	}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	etag := contentETag(data)
+
+	a.m.Lock()
+	if a.etags == nil {
+		a.etags = make(map[string]assetETag)
+	}
+	a.etags[name] = assetETag{
+		size:    stat.Size(),
+		modtime: stat.ModTime(),
+		etag:    etag,
+	}
+	a.m.Unlock()
+
+	return etag, nil
+}
+
+// serveAsset sends a file from the assets with caching headers. Conditional
+// requests are answered with 304 when the client copy is still valid.
+func (s *Server) serveAsset(
+	w http.ResponseWriter,
+	r *http.Request,
+	path string,
+	contentType string,
+) {
+	logger := requestLogger(r)
+
+	f, err := s.fs.Open(path)
+	if os.IsNotExist(err) {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+	if err != nil {
+		logger.Errorf(err, "cannot load asset %v", path)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		logger.Errorf(err, "cannot stat asset %v", path)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	etag, err := s.assetETags.get(path, f, stat)
+	if err != nil {
+		logger.Errorf(err, "cannot read asset %v", path)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Cache-Control", assetCacheControl)
+	h.Add("Vary", "Accept-Encoding")
+
+	// embedded assets are already gzipped, send them as they are
+	_, embedded := s.fs.(_escStaticFS)
+	if embedded && acceptsEncoding(r, encodingGzip) {
+		if data, ok := s.gzipAssets.get(path); ok {
+			h.Set("Content-Encoding", encodingGzip)
+			h.Set("ETag", encodedETag(etag, encodingGzip))
+			http.ServeContent(w, r, path, stat.ModTime(), bytes.NewReader(data))
+			return
+		}
+	}
+
+	h.Set("ETag", etag)
+	http.ServeContent(w, r, path, stat.ModTime(), f)
+}
+
+// serveBytes sends the content with the given ETag and modification time
+// answering conditional requests.
+func serveBytes(
+	w http.ResponseWriter,
+	r *http.Request,
+	data []byte,
+	etag string,
+	modtime time.Time,
+) {
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "", modtime, bytes.NewReader(data))
+}
@@ -0,0 +1,169 @@
+package glsl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func cacheRequest(
+	h http.Handler,
+	path string,
+	headers map[string]string,
+) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func TestCacheAssets(t *testing.T) {
+	require := require.New(t)
+
+	server, _, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	for _, path := range []string{
+		"/js/helpers.js",
+		"/css/default.css",
+		"/css/resizer.png",
+		"/e",
+		"/diff",
+	} {
+		res := cacheRequest(h, path, nil)
+		require.Equal(http.StatusOK, res.Code, path)
+		require.NotEmpty(res.Body.Bytes(), path)
+
+		etag := res.Header().Get("ETag")
+		require.Equal(contentETag(res.Body.Bytes()), etag, path)
+		require.Equal(assetCacheControl, res.Header().Get("Cache-Control"), path)
+		lastModified := res.Header().Get("Last-Modified")
+		require.NotEmpty(lastModified, path)
+
+		res = cacheRequest(h, path, map[string]string{"If-None-Match": etag})
+		require.Equal(http.StatusNotModified, res.Code, path)
+		require.Empty(res.Body.Bytes(), path)
+
+		res = cacheRequest(h, path, map[string]string{
+			"If-None-Match": `"other", ` + etag,
+		})
+		require.Equal(http.StatusNotModified, res.Code, path)
+
+		res = cacheRequest(h, path, map[string]string{"If-None-Match": `"other"`})
+		require.Equal(http.StatusOK, res.Code, path)
+
+		res = cacheRequest(h, path, map[string]string{
+			"If-Modified-Since": lastModified,
+		})
+		require.Equal(http.StatusNotModified, res.Code, path)
+	}
+
+	res := cacheRequest(h, "/js/missing.js", nil)
+	require.Equal(http.StatusNotFound, res.Code)
+}
+
+func TestCacheImages(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	res := postSave(t, h, saveCode{Code: "code", Image: testImage, User: "u"})
+	require.Equal(http.StatusOK, res.Code)
+
+	res = cacheRequest(h, "/images/1.png", nil)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal("image/png", res.Header().Get("Content-Type"))
+	require.Equal(imageCacheControl, res.Header().Get("Cache-Control"))
+	etag := res.Header().Get("ETag")
+	require.Equal(contentETag(res.Body.Bytes()), etag)
+
+	e, err := store.Effect(1)
+	require.NoError(err)
+	lastModified := e.Versions[0].Created.UTC().Format(http.TimeFormat)
+	require.Equal(lastModified, res.Header().Get("Last-Modified"))
+
+	res = cacheRequest(h, "/images/1.png", map[string]string{
+		"If-None-Match": etag,
+	})
+	require.Equal(http.StatusNotModified, res.Code)
+	require.Empty(res.Body.Bytes())
+
+	res = cacheRequest(h, "/images/1.png", map[string]string{
+		"If-Modified-Since": lastModified,
+	})
+	require.Equal(http.StatusNotModified, res.Code)
+
+	// thumbnails have their own etag
+	res = cacheRequest(h, "/images/1.png?size=gallery", map[string]string{
+		"If-None-Match": etag,
+	})
+	require.Equal(http.StatusOK, res.Code)
+	require.NotEqual(etag, res.Header().Get("ETag"))
+}
+
+func TestCacheItem(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	created := time.Date(2019, 7, 1, 10, 0, 0, 0, time.UTC)
+	store.Add(&Effect{
+		ID:    1,
+		User:  "user",
+		Owner: "id-user",
+		Versions: []Version{
+			{Number: 0, Code: "first", Created: created},
+			{Number: 1, Code: "second", Created: created.Add(time.Hour)},
+		},
+	})
+
+	res := cacheRequest(h, "/item/1.0", nil)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal("application/json", res.Header().Get("Content-Type"))
+	require.Equal(`"1.0"`, res.Header().Get("ETag"))
+	require.Equal(immutableCacheControl, res.Header().Get("Cache-Control"))
+	require.Equal(created.Format(http.TimeFormat), res.Header().Get("Last-Modified"))
+
+	res = cacheRequest(h, "/item/1.0", map[string]string{"If-None-Match": `"1.0"`})
+	require.Equal(http.StatusNotModified, res.Code)
+	require.Equal(immutableCacheControl, res.Header().Get("Cache-Control"))
+
+	// conditional requests of missing items are not answered as unmodified
+	res = cacheRequest(h, "/item/2.5", map[string]string{"If-None-Match": `"2.5"`})
+	require.Equal(http.StatusNotFound, res.Code)
+	res = cacheRequest(h, "/item/1.5", map[string]string{"If-None-Match": `"1.5"`})
+	require.Equal(http.StatusNotFound, res.Code)
+
+	res = cacheRequest(h, "/item/1", nil)
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(`"1.1"`, res.Header().Get("ETag"))
+	require.Equal(revalidateCacheControl, res.Header().Get("Cache-Control"))
+
+	res = cacheRequest(h, "/item/1", map[string]string{"If-None-Match": `"1.1"`})
+	require.Equal(http.StatusNotModified, res.Code)
+
+	// a new version invalidates the latest one
+	res = postSave(t, h, saveCode{
+		CodeID: "1.1",
+		Code:   "third",
+		Image:  testImage,
+		User:   "user",
+	})
+	require.Equal(http.StatusOK, res.Code)
+
+	res = cacheRequest(h, "/item/1", map[string]string{"If-None-Match": `"1.1"`})
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(`"1.2"`, res.Header().Get("ETag"))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	glsl "github.com/jfontan/go-glslsandbox"
+
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// DBOptions defines the database flags. It is meant to be embedded in a
+// command struct.
+type DBOptions struct {
+	DBDriver string `long:"db-driver" env:"GLSL_DB_DRIVER" choice:"sqlite3" choice:"postgres" choice:"mysql" default:"sqlite3" description:"database driver"`
+	DBDSN    string `long:"db-dsn" env:"GLSL_DB_DSN" default:"effects.db" description:"database data source name, a file for sqlite3 or a connection string for postgres and mysql"`
+}
+
+func (o DBOptions) prepareDB() (*glsl.Database, error) {
+	return glsl.OpenDatabase(o.DBDriver, o.DBDSN)
+}
+
+// prepareCurrentDB opens the database and checks that it is on the latest
+// schema version.
+func (o DBOptions) prepareCurrentDB() (*glsl.Database, error) {
+	db, err := o.prepareDB()
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.CheckSchema()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	glsl "github.com/jfontan/go-glslsandbox"
+	"github.com/src-d/go-cli"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+func init() {
+	app.AddCommand(&exportCommand{})
+}
+
+type exportCommand struct {
+	cli.Command   `name:"export" short-description:"exports effects in the format read by import"`
+	DBOptions     `group:"Database Options"`
+	FilterOptions `group:"Filter Options"`
+
+	Args struct {
+		File string `positional-arg-name:"file" description:"output file, standard output when empty or -"`
+	} `positional-args:"true"`
+}
+
+// FilterOptions selects the exported effects.
+type FilterOptions struct {
+	FromID        uint   `long:"from-id" description:"first effect id exported"`
+	ToID          uint   `long:"to-id" description:"last effect id exported"`
+	User          string `long:"user" description:"export only the effects of this user"`
+	ModifiedSince string `long:"modified-since" description:"export only the effects modified since this date, in RFC 3339 or YYYY-MM-DD format"`
+}
+
+func (o FilterOptions) filter() (glsl.ExportFilter, error) {
+	filter := glsl.ExportFilter{
+		FromID: o.FromID,
+		ToID:   o.ToID,
+		User:   o.User,
+	}
+
+	if o.ModifiedSince != "" {
+		since, err := parseDate(o.ModifiedSince)
+		if err != nil {
+			return filter, err
+		}
+		filter.ModifiedSince = since
+	}
+
+	return filter, nil
+}
+
+// createOutput opens the file for writing, standard output when it is empty
+// or -.
+func createOutput(file string) (io.WriteCloser, error) {
+	if file == "" || file == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+
+	return os.Create(file)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func (e *exportCommand) Execute(args []string) error {
+	filter, err := e.filter()
+	if err != nil {
+		return err
+	}
+
+	db, err := e.prepareCurrentDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	w, err := createOutput(e.Args.File)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	count, err := db.ExportEffects(w, filter)
+	if err != nil {
+		return err
+	}
+
+	log.With(log.Fields{"effects": count}).Infof("export finished")
+	return nil
+}
+
+// parseDate parses a date in RFC 3339 format or only the day.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	glsl "github.com/jfontan/go-glslsandbox"
+)
+
+// ImageOptions defines the image storage flags. It is meant to be embedded in
+// a command struct.
+type ImageOptions struct {
+	ImagesStore    string        `long:"images-store" env:"GLSL_IMAGES_STORE" choice:"fs" choice:"s3" default:"fs" description:"storage for effect images"`
+	ImagesPath     string        `long:"images-path" env:"GLSL_IMAGES_PATH" default:"images" description:"directory for fs store or key prefix for s3"`
+	ImagesRedirect time.Duration `long:"images-redirect" env:"GLSL_IMAGES_REDIRECT" default:"0" description:"redirect image requests to presigned s3 urls valid for this duration, disabled when 0"`
+	S3Bucket       string        `long:"s3-bucket" env:"GLSL_S3_BUCKET" description:"s3 bucket for images"`
+	S3Endpoint     string        `long:"s3-endpoint" env:"GLSL_S3_ENDPOINT" description:"s3 compatible service address, uses aws when empty"`
+	S3Region       string        `long:"s3-region" env:"GLSL_S3_REGION" default:"us-east-1" description:"s3 region"`
+	S3AccessKey    string        `long:"s3-access-key" env:"GLSL_S3_ACCESS_KEY" description:"s3 access key, uses aws default credentials when empty"`
+	S3SecretKey    string        `long:"s3-secret-key" env:"GLSL_S3_SECRET_KEY" description:"s3 secret key"`
+	S3PathStyle    bool          `long:"s3-path-style" env:"GLSL_S3_PATH_STYLE" description:"use path style s3 urls, needed by most s3 compatible services"`
+}
+
+func (o ImageOptions) prepareImages() (glsl.ImageStore, error) {
+	switch o.ImagesStore {
+	case "fs":
+		return glsl.NewFSImageStore(o.ImagesPath)
+	case "s3":
+		if o.S3Bucket == "" {
+			return nil, fmt.Errorf("s3 bucket not set")
+		}
+
+		return glsl.NewS3ImageStore(glsl.S3Options{
+			Bucket:        o.S3Bucket,
+			Prefix:        o.ImagesPath,
+			Endpoint:      o.S3Endpoint,
+			Region:        o.S3Region,
+			AccessKey:     o.S3AccessKey,
+			SecretKey:     o.S3SecretKey,
+			PathStyle:     o.S3PathStyle,
+			URLExpiration: o.ImagesRedirect,
+		})
+	default:
+		return nil, fmt.Errorf("unknown images store %q", o.ImagesStore)
+	}
+}
+
+func (o ImageOptions) serverOptions() []glsl.ServerOption {
+	var opts []glsl.ServerOption
+	if o.ImagesRedirect > 0 {
+		opts = append(opts, glsl.WithImageRedirect())
+	}
+
+	return opts
+}
@@ -1,14 +1,11 @@
 package main
 
 import (
-	"bufio"
 	"os"
 
 	glsl "github.com/jfontan/go-glslsandbox"
 	"github.com/src-d/go-cli"
-
-	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"gopkg.in/src-d/go-log.v1"
 )
 
 func init() {
@@ -16,7 +13,17 @@ func init() {
 }
 
 type importCommand struct {
-	cli.Command `name:"import" short-description:"imports data from old mongodb dump"`
+	cli.Command  `name:"import" short-description:"imports data from old mongodb dump"`
+	DBOptions    `group:"Database Options"`
+	ImageOptions `group:"Image Options"`
+
+	BatchSize    int    `long:"batch-size" default:"500" description:"number of effects imported in each transaction"`
+	Checkpoint   string `long:"checkpoint" description:"file used to resume an interrupted import, by default the dump file name ending in .checkpoint"`
+	NoCheckpoint bool   `long:"no-checkpoint" description:"import the whole file without using a checkpoint"`
+	Workers      int    `long:"workers" description:"number of goroutines decoding effects, by default the number of CPUs"`
+	Thumbnails   string `long:"thumbnails" description:"directory or tarball with the images referenced by image_url, images are not imported when empty"`
+	MissingFile  string `long:"missing-images" description:"file where the effects whose image could not be imported are appended as JSON lines"`
+	KeepImageURL bool   `long:"keep-image-url" description:"store the image_url of the dump in the effects"`
 
 	Args struct {
 		File string `positional-arg-name:"file"`
@@ -24,73 +31,83 @@ type importCommand struct {
 }
 
 func (i *importCommand) Execute(args []string) error {
-	db, err := prepareDB()
+	db, err := i.prepareCurrentDB()
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	err = importEffects(db, i.Args.File)
+	f, err := os.Open(i.Args.File)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	return nil
-}
-
-func prepareDB() (*gorm.DB, error) {
-	db, err := gorm.Open("sqlite3", "effects.db")
+	stat, err := f.Stat()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	db.AutoMigrate(&glsl.Effect{})
-	db.AutoMigrate(&glsl.Version{})
+	opts := glsl.ImportOptions{
+		BatchSize:  i.BatchSize,
+		Checkpoint: i.Checkpoint,
+		Workers:    i.Workers,
+		Size:       stat.Size(),
 
-	errs := db.GetErrors()
-	if len(errs) != 0 {
-		return nil, errs[0]
+		KeepImageURL: i.KeepImageURL,
 	}
-
-	return db, nil
-}
-
-func importEffects(db *gorm.DB, file string) error {
-	f, err := os.Open(file)
-	if err != nil {
-		return err
+	if opts.Checkpoint == "" {
+		opts.Checkpoint = i.Args.File + ".checkpoint"
+	}
+	if i.NoCheckpoint {
+		opts.Checkpoint = ""
 	}
 
-	bufSize := 64 * 1024 * 1024
-	buf := make([]byte, bufSize)
-
-	db.Begin()
-
-	scanner := bufio.NewScanner(f)
-	scanner.Buffer(buf, len(buf))
-	for scanner.Scan() {
-		line := []byte(scanner.Text())
+	if i.Thumbnails != "" {
+		opts.Images, err = i.prepareImages()
+		if err != nil {
+			return err
+		}
 
-		effect, err := glsl.LoadEffect(line)
+		opts.Thumbnails, err = glsl.OpenThumbnails(i.Thumbnails)
 		if err != nil {
-			println("cannot unmarshal", err.Error())
-			continue
+			return err
 		}
+		defer opts.Thumbnails.Close()
+	}
 
-		if effect.ID%100 == 0 {
-			println("id", effect.ID, effect.User, len(line))
+	if i.MissingFile != "" {
+		// appended so the ones found before resuming are kept
+		missing, err := os.OpenFile(i.MissingFile,
+			os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
 		}
+		defer missing.Close()
 
-		db.Create(effect)
+		opts.MissingImages = missing
+	}
 
-		errs := db.GetErrors()
-		if len(errs) != 0 {
-			println("error", effect.ID, err.Error())
-			return errs[0]
-		}
+	report, err := db.Import(f, opts)
+	logger := log.With(log.Fields{
+		"resumed":  report.Resumed,
+		"imported": report.Imported,
+		"updated":  report.Updated,
+		"skipped":  report.Skipped,
+		"failed":   report.Failed,
+		"images":   report.Images,
+		"missing":  report.MissingImages,
+	})
+	if err != nil {
+		logger.Errorf(err, "import interrupted")
+		return err
 	}
 
-	db.Commit()
+	if report.Failed > 0 {
+		logger.Warningf("import finished with errors")
+	} else {
+		logger.Infof("import finished")
+	}
 
-	return nil
+	return db.ResetSequences()
 }
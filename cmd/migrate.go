@@ -0,0 +1,48 @@
+package main
+
+import (
+	glsl "github.com/jfontan/go-glslsandbox"
+	"github.com/src-d/go-cli"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+func init() {
+	app.AddCommand(&migrateCommand{})
+}
+
+type migrateCommand struct {
+	cli.Command `name:"migrate" short-description:"updates the database schema"`
+	DBOptions   `group:"Database Options"`
+
+	To     int  `long:"to" default:"-1" description:"schema version to migrate to, defaults to the latest, 0 removes all tables"`
+	Status bool `long:"status" description:"show the schema version and exit"`
+}
+
+func (m *migrateCommand) Execute(args []string) error {
+	db, err := m.prepareDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	log.With(log.Fields{
+		"version": version,
+		"latest":  glsl.LatestSchema(),
+	}).Infof("database schema")
+
+	if m.Status {
+		return nil
+	}
+
+	target := m.To
+	if target < 0 {
+		target = glsl.LatestSchema()
+	}
+
+	return db.Migrate(target)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	glsl "github.com/jfontan/go-glslsandbox"
+)
+
+// OAuthOptions defines the flags of the OAuth providers users can log in
+// with. It is meant to be embedded in a command struct.
+type OAuthOptions struct {
+	GitHubClientID     string `long:"github-client-id" env:"GLSL_GITHUB_CLIENT_ID" description:"client id of the GitHub OAuth app, enables logging in with GitHub"`
+	GitHubClientSecret string `long:"github-client-secret" env:"GLSL_GITHUB_CLIENT_SECRET" description:"client secret of the GitHub OAuth app"`
+}
+
+func (o OAuthOptions) serverOptions() ([]glsl.ServerOption, error) {
+	if (o.GitHubClientID == "") != (o.GitHubClientSecret == "") {
+		return nil, fmt.Errorf(
+			"both --github-client-id and --github-client-secret must be set")
+	}
+
+	var opts []glsl.ServerOption
+	if o.GitHubClientID != "" {
+		provider := glsl.NewGitHubProvider(o.GitHubClientID, o.GitHubClientSecret)
+		opts = append(opts, glsl.WithOAuthProvider(provider))
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+
+	glsl "github.com/jfontan/go-glslsandbox"
+)
+
+// RateLimitOptions defines the flags limiting the saves per client. It is
+// meant to be embedded in a command struct.
+type RateLimitOptions struct {
+	EffectRate     float64  `long:"effect-rate" env:"GLSL_EFFECT_RATE" default:"5" description:"new effects per minute allowed for each client, disabled when 0"`
+	EffectBurst    int      `long:"effect-burst" env:"GLSL_EFFECT_BURST" default:"20" description:"new effects a client can create at once"`
+	VersionRate    float64  `long:"version-rate" env:"GLSL_VERSION_RATE" default:"30" description:"new versions per minute allowed for each client, including the first ones of new effects, disabled when 0"`
+	VersionBurst   int      `long:"version-burst" env:"GLSL_VERSION_BURST" default:"60" description:"new versions a client can save at once"`
+	TrustedProxies []string `long:"trusted-proxy" env:"GLSL_TRUSTED_PROXIES" env-delim:"," description:"address or network of a proxy whose X-Forwarded-For and X-Real-IP headers are trusted, can be repeated"`
+}
+
+func (o RateLimitOptions) serverOptions() ([]glsl.ServerOption, error) {
+	proxies, err := glsl.ParseNetworks(o.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	effects, err := limiter(o.EffectRate, o.EffectBurst)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --effect-burst: %v", err)
+	}
+
+	versions, err := limiter(o.VersionRate, o.VersionBurst)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --version-burst: %v", err)
+	}
+
+	return []glsl.ServerOption{
+		glsl.WithRateLimits(effects, versions),
+		glsl.WithTrustedProxies(proxies),
+	}, nil
+}
+
+// limiter creates an in memory limiter from a rate per minute. It returns
+// nil if the rate is not positive.
+func limiter(perMinute float64, burst int) (glsl.RateLimiter, error) {
+	if perMinute <= 0 {
+		return nil, nil
+	}
+
+	l, err := glsl.NewMemoryRateLimiter(glsl.RateLimit{
+		Rate:  perMinute / 60,
+		Burst: burst,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
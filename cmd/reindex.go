@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/src-d/go-cli"
+)
+
+func init() {
+	app.AddCommand(&reindexCommand{})
+}
+
+type reindexCommand struct {
+	cli.Command `name:"reindex" short-description:"rebuilds the search index"`
+	DBOptions   `group:"Database Options"`
+}
+
+func (r *reindexCommand) Execute(args []string) error {
+	db, err := r.prepareCurrentDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Reindex()
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	glsl "github.com/jfontan/go-glslsandbox"
 	"github.com/src-d/go-cli"
+	"gopkg.in/src-d/go-log.v1"
 )
 
 func init() {
@@ -10,17 +15,83 @@ func init() {
 }
 
 type serverCommand struct {
-	cli.Command `name:"server" short-description:"start web service"`
+	cli.Command      `name:"server" short-description:"start web service"`
+	DBOptions        `group:"Database Options"`
+	ImageOptions     `group:"Image Options"`
+	RateLimitOptions `group:"Rate Limit Options"`
+	OAuthOptions     `group:"OAuth Options"`
+
+	Address             string        `long:"address" env:"GLSL_ADDRESS" default:":3000" description:"address the server listens on"`
+	ReadTimeout         time.Duration `long:"read-timeout" env:"GLSL_READ_TIMEOUT" default:"1m" description:"maximum time to read a request, disabled when 0"`
+	WriteTimeout        time.Duration `long:"write-timeout" env:"GLSL_WRITE_TIMEOUT" default:"1m" description:"maximum time to write a response, disabled when 0"`
+	IdleTimeout         time.Duration `long:"idle-timeout" env:"GLSL_IDLE_TIMEOUT" default:"2m" description:"maximum time to keep idle connections open, disabled when 0"`
+	ShutdownTimeout     time.Duration `long:"shutdown-timeout" env:"GLSL_SHUTDOWN_TIMEOUT" default:"30s" description:"time given to in flight requests to finish on shutdown, waits forever when 0"`
+	TLSCert             string        `long:"tls-cert" env:"GLSL_TLS_CERT" description:"certificate file, enables https when set with --tls-key"`
+	TLSKey              string        `long:"tls-key" env:"GLSL_TLS_KEY" description:"certificate key file"`
+	SessionSecret       string        `long:"session-secret" env:"GLSL_SESSION_SECRET" description:"key used to sign session cookies, required unless --random-session-secret is set"`
+	RandomSessionSecret bool          `long:"random-session-secret" env:"GLSL_RANDOM_SESSION_SECRET" description:"sign session cookies with a random key when --session-secret is empty, sessions are lost on restart, used during development"`
+	LocalAssets         bool          `long:"local-assets" env:"GLSL_LOCAL_ASSETS" description:"serve the files of the assets directory instead of the embedded ones, used during development"`
 }
 
-func (i *serverCommand) Execute(args []string) error {
-	db, err := prepareDB()
+func (i *serverCommand) ExecuteContext(ctx context.Context, args []string) error {
+	if (i.TLSCert == "") != (i.TLSKey == "") {
+		return fmt.Errorf("both --tls-cert and --tls-key must be set")
+	}
+
+	secret, err := i.sessionSecret()
+	if err != nil {
+		return err
+	}
+
+	limits, err := i.RateLimitOptions.serverOptions()
+	if err != nil {
+		return err
+	}
+
+	providers, err := i.OAuthOptions.serverOptions()
+	if err != nil {
+		return err
+	}
+
+	db, err := i.prepareCurrentDB()
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	server := glsl.NewServer(db, true)
-	server.Start()
-	return nil
+	images, err := i.prepareImages()
+	if err != nil {
+		return err
+	}
+
+	opts := append(i.ImageOptions.serverOptions(), limits...)
+	opts = append(opts, providers...)
+	opts = append(opts,
+		glsl.WithAddress(i.Address),
+		glsl.WithTimeouts(i.ReadTimeout, i.WriteTimeout, i.IdleTimeout),
+		glsl.WithShutdownTimeout(i.ShutdownTimeout),
+	)
+	if i.TLSCert != "" {
+		opts = append(opts, glsl.WithTLS(i.TLSCert, i.TLSKey))
+	}
+	opts = append(opts, glsl.WithSessionSecret(secret))
+
+	server := glsl.NewServer(db, images, i.LocalAssets, opts...)
+	return server.Start(ctx)
+}
+
+// sessionSecret returns the secret given in the options or a random one if
+// it is empty and --random-session-secret is set.
+func (i *serverCommand) sessionSecret() ([]byte, error) {
+	if i.SessionSecret != "" {
+		return []byte(i.SessionSecret), nil
+	}
+
+	if !i.RandomSessionSecret {
+		return nil, fmt.Errorf("--session-secret must be set, " +
+			"use --random-session-secret during development")
+	}
+
+	log.Warningf("using a random session secret, sessions are lost on restart")
+	return glsl.NewSessionSecret()
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+
+	glsl "github.com/jfontan/go-glslsandbox"
+	"github.com/src-d/go-cli"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+func init() {
+	app.AddCommand(&shadertoyImportCommand{})
+	app.AddCommand(&shadertoyExportCommand{})
+}
+
+type shadertoyImportCommand struct {
+	cli.Command `name:"import-shadertoy" short-description:"imports single pass shaders from Shadertoy JSON"`
+	DBOptions   `group:"Database Options"`
+
+	Args struct {
+		Files []string `positional-arg-name:"file" description:"Shadertoy API response or export of all the shaders of a user"`
+	} `positional-args:"true" required:"yes"`
+}
+
+func (i *shadertoyImportCommand) Execute(args []string) error {
+	db, err := i.prepareCurrentDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	total := new(glsl.ShadertoyReport)
+	for _, file := range i.Args.Files {
+		shaders, err := readShadertoyFile(file)
+		if err != nil {
+			log.Errorf(err, "cannot read %v", file)
+			return err
+		}
+
+		report, err := db.ImportShadertoy(shaders)
+		addShadertoyReport(total, report)
+		if err != nil {
+			return err
+		}
+	}
+
+	logShadertoyReport(total, "import")
+	return db.ResetSequences()
+}
+
+func readShadertoyFile(file string) ([]glsl.ShadertoyShader, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return glsl.ReadShadertoy(f)
+}
+
+type shadertoyExportCommand struct {
+	cli.Command   `name:"export-shadertoy" short-description:"exports the last version of effects as Shadertoy JSON"`
+	DBOptions     `group:"Database Options"`
+	FilterOptions `group:"Filter Options"`
+
+	Args struct {
+		File string `positional-arg-name:"file" description:"output file, standard output when empty or -"`
+	} `positional-args:"true"`
+}
+
+func (e *shadertoyExportCommand) Execute(args []string) error {
+	filter, err := e.filter()
+	if err != nil {
+		return err
+	}
+
+	db, err := e.prepareCurrentDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	w, err := createOutput(e.Args.File)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	report, err := db.ExportShadertoy(w, filter)
+	if err != nil {
+		return err
+	}
+
+	logShadertoyReport(report, "export")
+	return nil
+}
+
+func addShadertoyReport(total, report *glsl.ShadertoyReport) {
+	total.Converted += report.Converted
+	total.Skipped += report.Skipped
+	total.Failed += report.Failed
+	total.Unsupported = append(total.Unsupported, report.Unsupported...)
+}
+
+// logShadertoyReport logs the shaders that could not be converted and the
+// totals of the operation.
+func logShadertoyReport(report *glsl.ShadertoyReport, operation string) {
+	for _, u := range report.Unsupported {
+		log.With(log.Fields{"id": u.ID, "features": u.Features}).
+			Warningf("unsupported shader")
+	}
+
+	logger := log.With(log.Fields{
+		"converted":   report.Converted,
+		"skipped":     report.Skipped,
+		"failed":      report.Failed,
+		"unsupported": len(report.Unsupported),
+	})
+	if report.Failed > 0 || len(report.Unsupported) > 0 {
+		logger.Warningf("%v finished with errors", operation)
+	} else {
+		logger.Infof("%v finished", operation)
+	}
+}
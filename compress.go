@@ -0,0 +1,179 @@
+package glsl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	encodingGzip   = "gzip"
+	encodingBrotli = "br"
+	// brotliLevel trades some compression ratio for speed as responses are
+	// compressed on each request.
+	brotliLevel = 5
+)
+
+// acceptsEncoding checks if the Accept-Encoding header of the request allows
+// the given encoding.
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, e := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(e, ";")
+		name := strings.TrimSpace(parts[0])
+		if name != encoding && name != "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, p := range parts[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				v, err := strconv.ParseFloat(p[2:], 64)
+				if err == nil {
+					q = v
+				}
+			}
+		}
+
+		return q > 0
+	}
+
+	return false
+}
+
+// negotiateEncoding returns the encoding used to compress the response,
+// brotli preferred over gzip, or an empty string if the client does not
+// accept any of them.
+func negotiateEncoding(r *http.Request) string {
+	for _, e := range []string{encodingBrotli, encodingGzip} {
+		if acceptsEncoding(r, e) {
+			return e
+		}
+	}
+
+	return ""
+}
+
+// compress is a middleware that compresses the responses with brotli or
+// gzip when the client accepts them.
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r)
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter compresses the body written unless the response has no
+// body or it is already encoded.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	w           io.WriteCloser
+	wroteHeader bool
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+
+	h := c.Header()
+	if status != http.StatusNoContent &&
+		status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", c.encoding)
+		h.Del("Content-Length")
+
+		switch c.encoding {
+		case encodingBrotli:
+			c.w = brotli.NewWriterLevel(c.ResponseWriter, brotliLevel)
+		default:
+			c.w = gzip.NewWriter(c.ResponseWriter)
+		}
+	}
+
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		// the content type must be detected before compressing the data
+		if c.Header().Get("Content-Type") == "" {
+			c.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		c.WriteHeader(http.StatusOK)
+	}
+
+	if c.w == nil {
+		return c.ResponseWriter.Write(b)
+	}
+
+	return c.w.Write(b)
+}
+
+// Close flushes the compressed data.
+func (c *compressWriter) Close() error {
+	if c.w == nil {
+		return nil
+	}
+
+	return c.w.Close()
+}
+
+// gzipAssets keeps the decoded gzip data of the embedded assets.
+type gzipAssets struct {
+	m    sync.Mutex
+	data map[string][]byte
+}
+
+// get returns the gzip compressed content of an embedded asset as stored by
+// esc. It returns false if the asset does not exist or is empty.
+func (g *gzipAssets) get(name string) ([]byte, bool) {
+	name = path.Clean(name)
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if data, ok := g.data[name]; ok {
+		return data, data != nil
+	}
+
+	var data []byte
+	f, ok := _escData[name]
+	if ok && f.size > 0 && !f.isDir {
+		b64 := base64.NewDecoder(base64.StdEncoding,
+			bytes.NewBufferString(f.compressed))
+		var err error
+		data, err = ioutil.ReadAll(b64)
+		if err != nil {
+			data = nil
+		}
+	}
+
+	if g.data == nil {
+		g.data = make(map[string][]byte)
+	}
+	g.data[name] = data
+
+	return data, data != nil
+}
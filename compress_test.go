@@ -0,0 +1,188 @@
+package glsl
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		encoding string
+		expected bool
+	}{
+		{"", "gzip", false},
+		{"gzip", "gzip", true},
+		{"deflate, gzip", "gzip", true},
+		{"gzip;q=0.5", "gzip", true},
+		{"gzip;q=0", "gzip", false},
+		{"*", "br", true},
+		{"gzip, deflate", "br", false},
+		{"br;q=1.0, gzip;q=0.8", "br", true},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", test.header)
+		require.Equal(t, test.expected, acceptsEncoding(req, test.encoding),
+			"%q %v", test.header, test.encoding)
+	}
+}
+
+func encodedRequest(h http.Handler, path, encoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	if encoding != "" {
+		req.Header.Set("Accept-Encoding", encoding)
+	}
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func decodeBody(t *testing.T, res *httptest.ResponseRecorder) []byte {
+	t.Helper()
+
+	var r io.Reader
+	switch res.Header().Get("Content-Encoding") {
+	case "":
+		r = res.Body
+	case encodingGzip:
+		gr, err := gzip.NewReader(res.Body)
+		require.NoError(t, err)
+		r = gr
+	case encodingBrotli:
+		r = brotli.NewReader(res.Body)
+	default:
+		require.FailNow(t, "unknown encoding")
+	}
+
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	return data
+}
+
+func TestCompressAssets(t *testing.T) {
+	require := require.New(t)
+
+	server, _, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	res := encodedRequest(h, "/js/helpers.js", "")
+	require.Equal(http.StatusOK, res.Code)
+	require.Empty(res.Header().Get("Content-Encoding"))
+	require.Equal("Accept-Encoding", res.Header().Get("Vary"))
+	plain := res.Body.Bytes()
+	etag := res.Header().Get("ETag")
+
+	// embedded assets are already gzipped, brotli is not used
+	res = encodedRequest(h, "/js/helpers.js", "br, gzip")
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal(encodingGzip, res.Header().Get("Content-Encoding"))
+	require.Equal("Accept-Encoding", res.Header().Get("Vary"))
+	require.Equal("text/javascript", res.Header().Get("Content-Type"))
+	gzipETag := res.Header().Get("ETag")
+	require.NotEqual(etag, gzipETag)
+	require.Equal(plain, decodeBody(t, res))
+
+	req := httptest.NewRequest("GET", "/js/helpers.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", gzipETag)
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	require.Equal(http.StatusNotModified, res.Code)
+
+	res = encodedRequest(h, "/js/helpers.js", "br")
+	require.Empty(res.Header().Get("Content-Encoding"))
+	require.Equal(plain, res.Body.Bytes())
+}
+
+func TestCompressLocalAssets(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	images, err := NewFSImageStore(dir)
+	require.NoError(err)
+
+	// local files served during development are not compressed
+	server := NewServer(NewMemoryStore(), images, true)
+	res := encodedRequest(server.router(), "/js/helpers.js", "gzip")
+	require.Equal(http.StatusOK, res.Code)
+	require.Empty(res.Header().Get("Content-Encoding"))
+	require.Equal("Accept-Encoding", res.Header().Get("Vary"))
+
+	local, err := ioutil.ReadFile("assets/js/helpers.js")
+	require.NoError(err)
+	require.Equal(local, res.Body.Bytes())
+}
+
+func TestCompressGallery(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	store.Add(&Effect{ID: 1, Versions: []Version{{Code: "code"}}})
+
+	for _, encoding := range []string{"", "gzip", "br", "gzip;q=0"} {
+		res := encodedRequest(h, "/", encoding)
+		require.Equal(http.StatusOK, res.Code)
+		require.Equal("Accept-Encoding", res.Header().Get("Vary"))
+		require.Equal("text/html; charset=utf-8", res.Header().Get("Content-Type"))
+
+		expected := encoding
+		if encoding == "gzip;q=0" {
+			expected = ""
+		}
+		require.Equal(expected, res.Header().Get("Content-Encoding"), encoding)
+
+		body := decodeBody(t, res)
+		require.True(strings.Contains(string(body), "/images/1.png"), encoding)
+	}
+}
+
+func TestCompressAPI(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	store.Add(&Effect{ID: 1, Versions: []Version{{Code: "code"}}})
+
+	for _, encoding := range []string{"", "gzip", "br"} {
+		res := encodedRequest(h, apiPrefix+"/effects/1", encoding)
+		require.Equal(http.StatusOK, res.Code)
+		require.Equal("Accept-Encoding", res.Header().Get("Vary"))
+		require.Equal("application/json", res.Header().Get("Content-Type"))
+		require.Equal(encoding, res.Header().Get("Content-Encoding"))
+
+		var effect apiEffect
+		require.NoError(json.Unmarshal(decodeBody(t, res), &effect))
+		require.Equal(uint(1), effect.ID)
+	}
+
+	// errors are compressed too
+	res := encodedRequest(h, apiPrefix+"/effects/2", "gzip")
+	require.Equal(http.StatusNotFound, res.Code)
+	require.Equal(encodingGzip, res.Header().Get("Content-Encoding"))
+
+	var apiErr apiError
+	require.NoError(json.Unmarshal(decodeBody(t, res), &apiErr))
+	require.Equal(http.StatusNotFound, apiErr.Error.Status)
+}
@@ -1,22 +1,42 @@
 package glsl
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 	"gopkg.in/src-d/go-log.v1"
 )
 
+const (
+	// pqUniqueViolation is the postgres error code of unique_violation.
+	pqUniqueViolation = "23505"
+	// mysqlDuplicateEntry is the mysql error number of ER_DUP_ENTRY.
+	mysqlDuplicateEntry = 1062
+)
+
 type Effect struct {
 	ID            uint `json:"_id" gorm:"primary_key"`
 	Created       time.Time
 	Modified      time.Time `gorm:"index:modified"`
 	Parent        *Effect
-	ParentID      uint   `json:"parent" sql:"parent_id:null"`
+	ParentID      uint   `json:"parent" sql:"parent_id:null" gorm:"index:parent_id"`
 	ParentVersion int    `json:"parent_version"`
 	User          string `json:"user,omitempty"`
-	Versions      []Version
+	// Owner is the server issued identity that created the effect. Only
+	// the owner can add versions. Effects created before identities were
+	// added have none and are always forked.
+	Owner string `json:"owner,omitempty" gorm:"size:64;index:owner"`
+	// ImageURL is the path of the thumbnail in the legacy dump. It is only
+	// kept when requested on import.
+	ImageURL string `json:"image_url,omitempty" gorm:"size:255"`
+	Versions []Version
 }
 
 func (e *Effect) LastVersion() int {
@@ -42,11 +62,14 @@ type effectJSON struct {
 
 type Version struct {
 	ID       uint
-	EffectID uint `gorm:"index:effect_id"`
+	EffectID uint `gorm:"index:effect_id;unique_index:effect_version"`
 
-	Number  int
+	Number  int `gorm:"unique_index:effect_version"`
 	Created time.Time
 	Code    string `gorm:"type:text"`
+	// Source is the address of the shader the code was imported from. It is
+	// empty for code written in the editor.
+	Source string `gorm:"size:255;index:version_source"`
 }
 
 type versionJSON struct {
@@ -87,21 +110,112 @@ func LoadEffect(text []byte) (*Effect, error) {
 	return &effect.Effect, nil
 }
 
+// Database drivers supported by OpenDatabase.
+const (
+	SQLite   = "sqlite3"
+	Postgres = "postgres"
+	MySQL    = "mysql"
+)
+
 type Database struct {
 	*gorm.DB
+	logger log.Logger
 }
 
 func NewDatabase(db *gorm.DB) *Database {
 	return &Database{DB: db}
 }
 
+// OpenDatabase connects to the database with the given driver and data source
+// name. The driver must be registered importing its gorm dialect. The schema
+// is not created, use Migrate for that.
+func OpenDatabase(driver, dsn string) (*Database, error) {
+	switch driver {
+	case SQLite:
+		dsn = addDSNParams(dsn, sqliteParams)
+	case Postgres:
+	case MySQL:
+		dsn = addDSNParams(dsn, mysqlParams)
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+
+	db, err := gorm.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// every connection to an in memory sqlite database opens a new one
+	if driver == SQLite && strings.Contains(dsn, ":memory:") {
+		db.DB().SetMaxOpenConns(1)
+	}
+
+	return NewDatabase(db), nil
+}
+
+var (
+	// sqliteParams make writers wait for the lock instead of failing.
+	// Transactions take the write lock when they start so two of them cannot
+	// deadlock upgrading a read lock.
+	sqliteParams = []string{"_busy_timeout=10000", "_txlock=immediate"}
+	// mysqlParams are needed to scan dates and store utf8 code.
+	mysqlParams = []string{"parseTime=true", "charset=utf8mb4"}
+)
+
+// addDSNParams adds the parameters not already set to the data source name.
+func addDSNParams(dsn string, params []string) string {
+	for _, p := range params {
+		key := strings.Split(p, "=")[0] + "="
+		if strings.Contains(dsn, key) {
+			continue
+		}
+
+		if strings.Contains(dsn, "?") {
+			dsn += "&" + p
+		} else {
+			dsn += "?" + p
+		}
+	}
+
+	return dsn
+}
+
+// ResetSequences makes new effects and versions get identifiers after the
+// ones inserted with explicit values, for example by import. It is only
+// needed in PostgreSQL, the other databases already do it on insert.
+func (d *Database) ResetSequences() error {
+	if d.Dialect().GetName() != Postgres {
+		return nil
+	}
+
+	for _, table := range []string{"effects", "versions"} {
+		err := d.Exec(fmt.Sprintf(
+			"SELECT setval(pg_get_serial_sequence('%[1]s', 'id'), "+
+				"COALESCE(MAX(id), 0) + 1, false) FROM %[1]s", table,
+		)).Error
+		if err != nil {
+			d.log().Errorf(err, "cannot reset sequence of %v", table)
+			return err
+		}
+	}
+
+	return nil
+}
+
+var _ Store = new(Database)
+
 func (d *Database) Effect(id int) (*Effect, error) {
 	var effect Effect
-	db := d.Preload("Versions").Find(&effect, id)
+	db := d.Preload("Versions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("number")
+	}).Find(&effect, id)
 
 	err := db.Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
-		log.Errorf(err, "cannot retrieve effect%v", id)
+		d.log().Errorf(err, "cannot retrieve effect %v", id)
 		return nil, err
 	}
 
@@ -110,21 +224,94 @@ func (d *Database) Effect(id int) (*Effect, error) {
 
 func (d *Database) Effects(page, size int) ([]Effect, error) {
 	var effects []Effect
-	db := d.Order("modified desc").Limit(perPage).Offset(page * perPage).
+	db := d.Order("modified desc, id desc").Limit(size).Offset(page * size).
 		Preload("Versions").Find(&effects)
 	err := db.Error
 	if err != nil {
-		log.Errorf(err, "cannot retrieve effects")
+		d.log().Errorf(err, "cannot retrieve effects")
+		return nil, err
+	}
+
+	return effects, nil
+}
+
+func (d *Database) Children(ids ...uint) ([]Effect, error) {
+	var effects []Effect
+	if len(ids) == 0 {
+		return effects, nil
+	}
+
+	db := d.Where("parent_id IN (?)", ids).Order("id").
+		Preload("Versions", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, effect_id, number, created").Order("number")
+		}).Find(&effects)
+	err := db.Error
+	if err != nil {
+		d.log().Errorf(err, "cannot retrieve children of %v", ids)
 		return nil, err
 	}
 
 	return effects, nil
 }
 
+func (d *Database) EffectCount() (int, error) {
+	var count int
+	err := d.Model(&Effect{}).Count(&count).Error
+	if err != nil {
+		d.log().Errorf(err, "cannot count effects")
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (d *Database) VersionCount() (int, error) {
+	var count int
+	err := d.Model(&Version{}).Count(&count).Error
+	if err != nil {
+		d.log().Errorf(err, "cannot count versions")
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (d *Database) LastVersionTime(id int) (time.Time, error) {
+	var version Version
+	err := d.Select("created").Where("effect_id = ?", id).
+		Order("number desc").First(&version).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		d.log().Errorf(err, "cannot retrieve last version of %v", id)
+		return time.Time{}, err
+	}
+
+	return version.Created, nil
+}
+
+// Ping checks the connection to the database. Inside a transaction the
+// connection is already in use so it always succeeds.
+func (d *Database) Ping() error {
+	db, ok := d.CommonDB().(*sql.DB)
+	if !ok {
+		return nil
+	}
+
+	err := db.Ping()
+	if err != nil {
+		d.log().Errorf(err, "cannot connect to database")
+		return err
+	}
+
+	return nil
+}
+
 func (d *Database) UpdateTime(e *Effect) error {
 	err := d.DB.Model(e).Update("modified", time.Now()).Error
 	if err != nil {
-		log.Errorf(err, "cannot update effect time")
+		d.log().Errorf(err, "cannot update effect time")
 		return err
 	}
 
@@ -133,7 +320,7 @@ func (d *Database) UpdateTime(e *Effect) error {
 
 func (d *Database) NewEffect(
 	parent, version int,
-	user string,
+	user, owner string,
 ) (*Effect, error) {
 	effect := &Effect{
 		Created:       time.Now(),
@@ -141,13 +328,115 @@ func (d *Database) NewEffect(
 		ParentID:      uint(parent),
 		ParentVersion: version,
 		User:          user,
+		Owner:         owner,
 	}
 
 	err := d.Create(effect).Error
 	if err != nil {
-		log.Errorf(err, "cannot create effect")
+		d.log().Errorf(err, "cannot create effect")
 		return nil, err
 	}
 
 	return effect, nil
 }
+
+// AddVersion calculates the version number from the ones stored in the
+// database. ErrConflict is returned when other version with the same number
+// was added concurrently. In this case the transaction should be retried.
+func (d *Database) AddVersion(e *Effect, code string) (*Version, error) {
+	var number int
+	err := d.Model(&Version{}).Where("effect_id = ?", e.ID).
+		Select("COALESCE(MAX(number), -1) + 1").Row().Scan(&number)
+	if err != nil {
+		d.log().Errorf(err, "could not get next version number %v", e.ID)
+		return nil, err
+	}
+
+	version := &Version{
+		EffectID: e.ID,
+		Number:   number,
+		Created:  time.Now(),
+		Code:     code,
+	}
+
+	err = d.Create(version).Error
+	if isUniqueViolation(err) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		d.log().Errorf(err, "could not create version %v", e.ID)
+		return nil, err
+	}
+
+	e.Versions = append(e.Versions, *version)
+
+	err = d.IndexEffect(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return version, nil
+}
+
+// isUniqueViolation checks the errors returned by the drivers of the
+// supported databases when a unique index is violated.
+func isUniqueViolation(err error) bool {
+	switch err := err.(type) {
+	case sqlite3.Error:
+		return err.ExtendedCode == sqlite3.ErrConstraintUnique
+	case *pq.Error:
+		return err.Code == pqUniqueViolation
+	case *mysql.MySQLError:
+		return err.Number == mysqlDuplicateEntry
+	default:
+		return false
+	}
+}
+
+// WithLogger returns a Database sharing the connection that logs with the
+// given logger.
+func (d *Database) WithLogger(logger log.Logger) Store {
+	return &Database{DB: d.DB, logger: logger}
+}
+
+// log returns the logger set with WithLogger or the default one.
+func (d *Database) log() log.Logger {
+	if d.logger != nil {
+		return d.logger
+	}
+
+	return defaultLogger()
+}
+
+func (d *Database) Transaction(fn func(Store) error) error {
+	return d.transaction(func(tx *Database) error {
+		return fn(tx)
+	})
+}
+
+// transaction is like Transaction but gives access to the Database
+// methods that are not part of Store.
+func (d *Database) transaction(fn func(*Database) error) error {
+	tx := d.Begin()
+	if tx.Error != nil {
+		d.log().Errorf(tx.Error, "cannot start transaction")
+		return tx.Error
+	}
+
+	err := fn(&Database{DB: tx, logger: d.logger})
+	if err != nil {
+		rerr := tx.Rollback().Error
+		if rerr != nil {
+			d.log().Errorf(rerr, "cannot rollback transaction")
+		}
+		return err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		d.log().Errorf(err, "cannot commit transaction")
+		return err
+	}
+
+	return nil
+}
@@ -1,9 +1,16 @@
 package glsl
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/require"
 )
 
@@ -16,6 +23,7 @@ var (
 		ParentID:      55848,
 		ParentVersion: 1,
 		User:          "d4dd013",
+		ImageURL:      "thumbs/55961.png",
 		Versions: []Version{
 			{
 				Number:  0,
@@ -52,3 +60,74 @@ func TestLoadSimple(t *testing.T) {
 		})
 	}
 }
+
+func TestIsUniqueViolation(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "nil"},
+		{name: "other", err: errors.New("UNIQUE constraint failed")},
+		{
+			name: "sqlite unique",
+			err: sqlite3.Error{
+				Code:         sqlite3.ErrConstraint,
+				ExtendedCode: sqlite3.ErrConstraintUnique,
+			},
+			expected: true,
+		},
+		{
+			name: "sqlite not null",
+			err: sqlite3.Error{
+				Code:         sqlite3.ErrConstraint,
+				ExtendedCode: sqlite3.ErrConstraintNotNull,
+			},
+		},
+		{
+			name:     "postgres unique",
+			err:      &pq.Error{Code: "23505"},
+			expected: true,
+		},
+		{
+			name: "postgres foreign key",
+			err:  &pq.Error{Code: "23503"},
+		},
+		{
+			name:     "mysql duplicate",
+			err:      &mysql.MySQLError{Number: 1062},
+			expected: true,
+		},
+		{
+			name: "mysql foreign key",
+			err:  &mysql.MySQLError{Number: 1452},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.expected, isUniqueViolation(test.err))
+		})
+	}
+}
+
+func TestIsUniqueViolationSQLite(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDatabase(SQLite, filepath.Join(dir, "effects.db"))
+	require.NoError(err)
+	defer db.Close()
+	require.NoError(db.Migrate(LatestSchema()))
+
+	version := Version{EffectID: 1, Number: 0}
+	require.NoError(db.Create(&version).Error)
+
+	version = Version{EffectID: 1, Number: 0}
+	err = db.Create(&version).Error
+	require.Error(err)
+	require.True(isUniqueViolation(err))
+}
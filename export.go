@@ -0,0 +1,148 @@
+package glsl
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// exportBatchSize is the number of effects loaded at once by ExportEffects.
+const exportBatchSize = 100
+
+// ExportFilter selects the effects written by ExportEffects. Zero values
+// do not filter.
+type ExportFilter struct {
+	// FromID and ToID are the first and last ids exported.
+	FromID uint
+	ToID   uint
+	User   string
+	// ModifiedSince exports only the effects modified at or after it.
+	ModifiedSince time.Time
+}
+
+// effectDump is an effect in the format of the old mongodb dump, read by
+// LoadEffect.
+type effectDump struct {
+	ID            uint          `json:"_id"`
+	CreatedAt     Timestamp     `json:"created_at"`
+	ModifiedAt    Timestamp     `json:"modified_at"`
+	Parent        *uint         `json:"parent,omitempty"`
+	ParentVersion *int          `json:"parent_version,omitempty"`
+	User          string        `json:"user"`
+	Owner         string        `json:"owner,omitempty"`
+	ImageURL      string        `json:"image_url,omitempty"`
+	Versions      []versionDump `json:"versions"`
+}
+
+type versionDump struct {
+	CreatedAt Timestamp `json:"created_at"`
+	Code      string    `json:"code"`
+	Source    string    `json:"source,omitempty"`
+}
+
+// NewTimestamp converts the time to a dump timestamp. It has millisecond
+// precision.
+func NewTimestamp(t time.Time) Timestamp {
+	return Timestamp{Date: t.UnixNano() / int64(time.Millisecond)}
+}
+
+// DumpEffect encodes the effect in the format read by LoadEffect. Times are
+// truncated to milliseconds.
+func DumpEffect(e *Effect) ([]byte, error) {
+	d := effectDump{
+		ID:         e.ID,
+		CreatedAt:  NewTimestamp(e.Created),
+		ModifiedAt: NewTimestamp(e.Modified),
+		User:       e.User,
+		Owner:      e.Owner,
+		ImageURL:   e.ImageURL,
+		Versions:   make([]versionDump, len(e.Versions)),
+	}
+
+	if e.ParentID != 0 {
+		parent, version := e.ParentID, e.ParentVersion
+		d.Parent = &parent
+		d.ParentVersion = &version
+	}
+
+	for i, v := range e.Versions {
+		d.Versions[i] = versionDump{
+			CreatedAt: NewTimestamp(v.Created),
+			Code:      v.Code,
+			Source:    v.Source,
+		}
+	}
+
+	return json.Marshal(d)
+}
+
+// ExportEffects writes the effects selected by the filter as JSON lines in
+// the format read by LoadEffect, sorted by id. It returns the number of
+// effects written.
+func (d *Database) ExportEffects(w io.Writer, filter ExportFilter) (int, error) {
+	buf := bufio.NewWriter(w)
+	var count int
+
+	err := d.exportEach(filter, func(e *Effect) error {
+		data, err := DumpEffect(e)
+		if err != nil {
+			return err
+		}
+
+		_, err = buf.Write(append(data, '\n'))
+		if err != nil {
+			return err
+		}
+		count++
+
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	return count, buf.Flush()
+}
+
+// exportEach calls fn with each effect selected by the filter, sorted by id.
+func (d *Database) exportEach(filter ExportFilter, fn func(*Effect) error) error {
+	query := d.DB
+	if filter.ToID > 0 {
+		query = query.Where("id <= ?", filter.ToID)
+	}
+	if filter.User != "" {
+		query = query.Where(&Effect{User: filter.User})
+	}
+	if !filter.ModifiedSince.IsZero() {
+		query = query.Where("modified >= ?", filter.ModifiedSince)
+	}
+
+	next := filter.FromID
+
+	for {
+		var effects []Effect
+		err := query.Where("id >= ?", next).Order("id").Limit(exportBatchSize).
+			Preload("Versions", func(db *gorm.DB) *gorm.DB {
+				return db.Order("number")
+			}).Find(&effects).Error
+		if err != nil {
+			d.log().Errorf(err, "cannot retrieve effects from %v", next)
+			return err
+		}
+
+		for i := range effects {
+			err = fn(&effects[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(effects) < exportBatchSize {
+			return nil
+		}
+		next = effects[len(effects)-1].ID + 1
+	}
+}
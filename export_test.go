@@ -0,0 +1,156 @@
+package glsl
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func migratedDatabase(t testing.TB) *Database {
+	t.Helper()
+
+	db := testDatabase(t)
+	require.NoError(t, db.Migrate(LatestSchema()))
+
+	return db
+}
+
+// importDump imports the JSON lines and returns the loaded effects.
+func importDump(t *testing.T, db *Database, dump []byte) []*Effect {
+	t.Helper()
+
+	var effects []*Effect
+	scanner := bufio.NewScanner(bytes.NewReader(dump))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		e, err := LoadEffect(scanner.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, db.ImportEffect(e))
+
+		// reload it as ImportEffect sets the version ids
+		e, err = LoadEffect(scanner.Bytes())
+		require.NoError(t, err)
+		effects = append(effects, e)
+	}
+	require.NoError(t, scanner.Err())
+
+	return effects
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	require := require.New(t)
+
+	owned := `{"_id":3,"created_at":{"$date":1562492504001},` +
+		`"modified_at":{"$date":1562492505999},"parent":55954,` +
+		`"parent_version":0,"user":"u","owner":"abc",` +
+		`"image_url":"thumbs/3.png",` +
+		`"versions":[{"created_at":{"$date":1562492504001},` +
+		`"code":"<&>\n\"unicode ñ\""}]}`
+	dump := []byte(strings.Join([]string{
+		effectVersionsJSON, effectSimpleJSON, owned,
+	}, "\n") + "\n")
+
+	db1 := migratedDatabase(t)
+	defer db1.Close()
+	original := importDump(t, db1, dump)
+
+	var exported bytes.Buffer
+	count, err := db1.ExportEffects(&exported, ExportFilter{})
+	require.NoError(err)
+	require.Equal(3, count)
+
+	db2 := migratedDatabase(t)
+	defer db2.Close()
+	imported := importDump(t, db2, exported.Bytes())
+
+	// exported by id
+	require.Len(imported, 3)
+	require.Equal(original[2], imported[0])
+	require.Equal(original[0], imported[1])
+	require.Equal(original[1], imported[2])
+
+	var again bytes.Buffer
+	_, err = db2.ExportEffects(&again, ExportFilter{})
+	require.NoError(err)
+	require.Equal(exported.String(), again.String())
+
+	// the stored effects are the same except the version ids, they are
+	// not part of the dump
+	for _, e := range original {
+		e1, err := db1.Effect(int(e.ID))
+		require.NoError(err)
+		e2, err := db2.Effect(int(e.ID))
+		require.NoError(err)
+
+		for _, e := range []*Effect{e1, e2} {
+			for i := range e.Versions {
+				e.Versions[i].ID = 0
+			}
+		}
+		require.Equal(e1, e2)
+	}
+
+	results, _, err := db2.Search("sdRoundBox", 0, 10)
+	require.NoError(err)
+	require.Len(results, 1)
+}
+
+func TestExportFilter(t *testing.T) {
+	require := require.New(t)
+
+	db := migratedDatabase(t)
+	defer db.Close()
+
+	base := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 250; i++ {
+		user := "a"
+		if i%2 == 0 {
+			user = "b"
+		}
+
+		require.NoError(db.ImportEffect(&Effect{
+			ID:       uint(i),
+			Created:  base,
+			Modified: base.Add(time.Duration(i) * time.Hour),
+			User:     user,
+			Versions: []Version{{Code: "code", Created: base}},
+		}))
+	}
+
+	ids := func(filter ExportFilter) []uint {
+		var buf bytes.Buffer
+		count, err := db.ExportEffects(&buf, filter)
+		require.NoError(err)
+
+		var ids []uint
+		scanner := bufio.NewScanner(&buf)
+		for scanner.Scan() {
+			e, err := LoadEffect(scanner.Bytes())
+			require.NoError(err)
+			require.Len(e.Versions, 1)
+			ids = append(ids, e.ID)
+		}
+		require.Len(ids, count)
+
+		return ids
+	}
+
+	// all of them in several batches
+	all := ids(ExportFilter{})
+	require.Len(all, 250)
+	for i, id := range all {
+		require.Equal(uint(i+1), id)
+	}
+
+	require.Equal([]uint{10, 11, 12}, ids(ExportFilter{FromID: 10, ToID: 12}))
+	require.Equal([]uint{11}, ids(ExportFilter{FromID: 10, ToID: 12, User: "a"}))
+	require.Equal([]uint{248, 249, 250}, ids(ExportFilter{
+		ModifiedSince: base.Add(248 * time.Hour),
+	}))
+	require.Len(ids(ExportFilter{User: "b"}), 125)
+	require.Empty(ids(ExportFilter{FromID: 300}))
+}
@@ -0,0 +1,100 @@
+package glsl
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// readyImage is the image written and deleted to check that the image store
+// accepts writes.
+const readyImage = ".readyz"
+
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// checkError is the error shown for failed checks. The endpoint is public so
+// the errors, that can have addresses or credentials, are only logged.
+const checkError = "unavailable"
+
+type healthCheck struct {
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency_ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+type healthStatus struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// healthz tells that the process is alive and serving requests.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthStatus{Status: checkOK})
+}
+
+// readyz checks the services needed to serve requests: the database, the
+// image store and the assets. It responds with 503 if any of them fails.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	db := s.store(r)
+	logger := requestLogger(r)
+
+	checks := []struct {
+		name string
+		fn   func() error
+	}{
+		{"database", db.Ping},
+		{"images", s.checkImages},
+		{"assets", s.checkAssets},
+	}
+
+	res := healthStatus{
+		Status: checkOK,
+		Checks: make(map[string]healthCheck),
+	}
+	status := http.StatusOK
+
+	for _, c := range checks {
+		start := time.Now()
+		err := c.fn()
+		check := healthCheck{
+			Status:  checkOK,
+			Latency: float64(time.Since(start)) / float64(time.Millisecond),
+		}
+
+		if err != nil {
+			logger.Errorf(err, "readiness check %v failed", c.name)
+			check.Status = checkFail
+			check.Error = checkError
+			res.Status = checkFail
+			status = http.StatusServiceUnavailable
+		}
+
+		res.Checks[c.name] = check
+	}
+
+	writeJSON(w, status, res)
+}
+
+// checkImages writes and deletes an image to make sure the store is
+// writable.
+func (s *Server) checkImages() error {
+	err := s.images.Put(readyImage, []byte(fmt.Sprint(time.Now().Unix())))
+	if err != nil {
+		return err
+	}
+
+	return s.images.Delete(readyImage)
+}
+
+// checkAssets opens the gallery template.
+func (s *Server) checkAssets() error {
+	f, err := s.fs.Open(galleryPath)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
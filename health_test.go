@@ -0,0 +1,82 @@
+package glsl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// failingPing is a Store that cannot be reached.
+type failingPing struct {
+	Store
+}
+
+func (failingPing) Ping() error {
+	return errFailure
+}
+
+func (f failingPing) WithLogger(logger log.Logger) Store {
+	return f
+}
+
+func TestHealth(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+
+	get := func(h http.Handler, path string) (int, healthStatus) {
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+		require.Equal("application/json", res.Header().Get("Content-Type"))
+
+		var status healthStatus
+		require.NoError(json.Unmarshal(res.Body.Bytes(), &status))
+		return res.Code, status
+	}
+
+	code, status := get(server.router(), "/healthz")
+	require.Equal(http.StatusOK, code)
+	require.Equal(checkOK, status.Status)
+
+	code, status = get(server.router(), "/readyz")
+	require.Equal(http.StatusOK, code)
+	require.Equal(checkOK, status.Status)
+	require.Len(status.Checks, 3)
+	for name, c := range status.Checks {
+		require.Equal(checkOK, c.Status, name)
+		require.Empty(c.Error, name)
+	}
+
+	ok, err := server.images.Exists(readyImage)
+	require.NoError(err)
+	require.False(ok)
+
+	// database down
+	server.db = failingPing{Store: store}
+	code, status = get(server.router(), "/readyz")
+	require.Equal(http.StatusServiceUnavailable, code)
+	require.Equal(checkFail, status.Status)
+	require.Equal(checkFail, status.Checks["database"].Status)
+	require.Equal(checkError, status.Checks["database"].Error)
+	require.Equal(checkOK, status.Checks["images"].Status)
+
+	// images not writable and missing assets
+	server.db = store
+	server.images = &failingImages{ImageStore: server.images, fail: "Put"}
+	server.fs = http.Dir(t.Name())
+	code, status = get(server.router(), "/readyz")
+	require.Equal(http.StatusServiceUnavailable, code)
+	require.Equal(checkOK, status.Checks["database"].Status)
+	require.Equal(checkFail, status.Checks["images"].Status)
+	require.Equal(checkFail, status.Checks["assets"].Status)
+	require.Equal(checkError, status.Checks["assets"].Error)
+
+	// the process is still alive
+	code, _ = get(server.router(), "/healthz")
+	require.Equal(http.StatusOK, code)
+}
@@ -0,0 +1,209 @@
+package glsl
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-log.v1"
+)
+
+const (
+	// sessionCookie holds the identity of the client signed by the server.
+	// It is not readable from javascript.
+	sessionCookie = "glsl_session"
+	// identityCookie holds only the identity so the editor can tell if the
+	// user owns an effect. It cannot be used to impersonate the user.
+	identityCookie = "glsl_id"
+	// sessionMaxAge is how long a session is valid and the browser keeps the
+	// cookies.
+	sessionMaxAge = 365 * 24 * time.Hour
+	// sessionRenewAge is the age of a session after which it is issued
+	// again, so the sessions of active clients do not expire.
+	sessionRenewAge = 24 * time.Hour
+	// identitySize is the number of random bytes of an identity.
+	identitySize = 16
+	// sessionSecretSize is the size of the generated secrets.
+	sessionSecretSize = 32
+)
+
+// ErrNoSessionSecret is returned when starting a server without a session
+// secret.
+var ErrNoSessionSecret = errors.New("session secret is not set")
+
+// WithSessionSecret sets the key used to sign the session cookies. All the
+// servers sharing the database must use the same one. It is required to
+// start the server, NewSessionSecret can generate one during development so
+// sessions are only valid while the process runs.
+func WithSessionSecret(secret []byte) ServerOption {
+	return func(s *Server) {
+		s.sessionSecret = secret
+	}
+}
+
+// NewSessionSecret generates a random secret to sign sessions.
+func NewSessionSecret() ([]byte, error) {
+	secret := make([]byte, sessionSecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// signSession returns the session cookie value for the identity issued at
+// the given time. The time is signed with the identity so sessions expire.
+func signSession(secret []byte, id string, issued time.Time) string {
+	value := id + "." + strconv.FormatInt(issued.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(value))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return value + "." + sig
+}
+
+// verifySession returns the identity of a session cookie value and the time
+// it was issued if its signature is valid and it is not older than maxAge.
+func verifySession(
+	secret []byte,
+	value string,
+	maxAge time.Duration,
+) (string, time.Time, bool) {
+	i := strings.LastIndex(value, ".")
+	if i <= 0 {
+		return "", time.Time{}, false
+	}
+
+	j := strings.LastIndex(value[:i], ".")
+	if j <= 0 {
+		return "", time.Time{}, false
+	}
+
+	unix, err := strconv.ParseInt(value[j+1:i], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	id := value[:j]
+	issued := time.Unix(unix, 0)
+	expected := signSession(secret, id, issued)
+	if !hmac.Equal([]byte(expected), []byte(value)) {
+		return "", time.Time{}, false
+	}
+
+	if time.Since(issued) > maxAge {
+		return "", time.Time{}, false
+	}
+
+	return id, issued, true
+}
+
+// csrfToken returns the token the forms of the client with the identity
+// must send. It is derived from the signed identity so other sites cannot
+// know it.
+func csrfToken(secret []byte, id string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("csrf." + id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newIdentity() (string, error) {
+	b := make([]byte, identitySize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// identify is a middleware that gets the identity of the client from its
+// session cookie. Clients without a valid one get a new identity.
+func (s *Server) identify(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			id     string
+			issued time.Time
+			ok     bool
+		)
+
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			id, issued, ok = verifySession(s.sessionSecret, c.Value, sessionMaxAge)
+		}
+
+		if !ok {
+			var err error
+			id, err = newIdentity()
+			if err != nil {
+				requestLogger(r).Errorf(err, "cannot create identity")
+				http.Error(w, http.StatusText(500), 500)
+				return
+			}
+
+			s.setSessionCookies(w, r, id)
+		} else if c, err := r.Cookie(identityCookie); err != nil || c.Value != id ||
+			time.Since(issued) > sessionRenewAge {
+			s.setSessionCookies(w, r, id)
+		}
+
+		logger := requestLogger(r).New(log.Fields{"identity": id})
+		ctx := context.WithValue(r.Context(), identityKey, id)
+		ctx = context.WithValue(ctx, loggerKey, logger)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (s *Server) setSessionCookies(w http.ResponseWriter, r *http.Request, id string) {
+	secure := s.secureRequest(r)
+	maxAge := int(sessionMaxAge.Seconds())
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    signSession(s.sessionSecret, id, time.Now()),
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     identityCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// secureRequest tells if the client connected with https, directly or
+// through a trusted proxy.
+func (s *Server) secureRequest(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return s.trustedProxy(host) &&
+		strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// requestIdentity returns the identity of the client or an empty string if
+// the request did not go through the identify middleware.
+func requestIdentity(r *http.Request) string {
+	id, _ := r.Context().Value(identityKey).(string)
+	return id
+}
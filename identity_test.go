@@ -0,0 +1,218 @@
+package glsl
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifySession(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Now()
+	value := signSession(testSecret, "abc", now)
+	id, issued, ok := verifySession(testSecret, value, time.Hour)
+	require.True(ok)
+	require.Equal("abc", id)
+	require.Equal(now.Unix(), issued.Unix())
+
+	_, _, ok = verifySession([]byte("other secret"), value, time.Hour)
+	require.False(ok)
+
+	for _, v := range []string{"", "abc", ".", "abc.", "abc..", "abd" + value[3:], value + "x"} {
+		_, _, ok = verifySession(testSecret, v, time.Hour)
+		require.False(ok, v)
+	}
+
+	// the issue time cannot be changed
+	i := strings.Index(value, ".")
+	j := strings.LastIndex(value, ".")
+	later := strconv.FormatInt(now.Unix()+1, 10)
+	_, _, ok = verifySession(testSecret, value[:i+1]+later+value[j:], time.Hour)
+	require.False(ok)
+
+	// old sessions expire
+	value = signSession(testSecret, "abc", now.Add(-2*time.Hour))
+	_, _, ok = verifySession(testSecret, value, time.Hour)
+	require.False(ok)
+}
+
+func responseCookies(res *httptest.ResponseRecorder) map[string]*http.Cookie {
+	cookies := make(map[string]*http.Cookie)
+	for _, c := range res.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+
+	return cookies
+}
+
+func TestIdentify(t *testing.T) {
+	require := require.New(t)
+
+	server, _, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	// a new identity is created on the first visit
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+	require.Equal(http.StatusOK, res.Code)
+
+	cookies := responseCookies(res)
+	require.Len(cookies, 2)
+	session := cookies[sessionCookie]
+	identity := cookies[identityCookie]
+	require.True(session.HttpOnly)
+	require.False(identity.HttpOnly)
+	require.False(session.Secure)
+	require.Equal(http.SameSiteLaxMode, session.SameSite)
+
+	id, _, ok := verifySession(testSecret, session.Value, sessionMaxAge)
+	require.True(ok)
+	require.Equal(id, identity.Value)
+	require.Len(id, 2*identitySize)
+
+	// valid sessions are kept
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(session)
+	req.AddCookie(identity)
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	require.Empty(res.Result().Cookies())
+
+	// the identity cookie is restored from the session
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(session)
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	cookies = responseCookies(res)
+	require.Equal(id, cookies[identityCookie].Value)
+	renewed, _, ok := verifySession(testSecret, cookies[sessionCookie].Value,
+		sessionMaxAge)
+	require.True(ok)
+	require.Equal(id, renewed)
+
+	// sessions are renewed after a day and expire after sessionMaxAge
+	old := time.Now().Add(-sessionRenewAge - time.Minute)
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{
+		Name:  sessionCookie,
+		Value: signSession(testSecret, id, old),
+	})
+	req.AddCookie(identity)
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	cookies = responseCookies(res)
+	_, issued, ok := verifySession(testSecret, cookies[sessionCookie].Value,
+		sessionMaxAge)
+	require.True(ok)
+	require.True(issued.After(old))
+	require.Equal(id, cookies[identityCookie].Value)
+
+	expired := time.Now().Add(-sessionMaxAge - time.Minute)
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{
+		Name:  sessionCookie,
+		Value: signSession(testSecret, id, expired),
+	})
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	require.NotEqual(id, responseCookies(res)[identityCookie].Value)
+
+	// forged sessions get a new identity
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: id + ".forged"})
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	cookies = responseCookies(res)
+	require.NotEqual(id, cookies[identityCookie].Value)
+
+	// cookies are secure for https clients
+	req = httptest.NewRequest("GET", "/", nil)
+	req.TLS = &tls.ConnectionState{}
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	require.True(responseCookies(res)[sessionCookie].Secure)
+
+	// static content does not need an identity
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/js/helpers.js", nil))
+	require.Equal(http.StatusOK, res.Code)
+	require.Empty(res.Result().Cookies())
+}
+
+func TestSaveOwnership(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	post := func(identity string, data saveCode) string {
+		body, err := json.Marshal(data)
+		require.NoError(err)
+
+		req := httptest.NewRequest("POST", "/e", bytes.NewReader(body))
+		req.AddCookie(&http.Cookie{
+			Name:  sessionCookie,
+			Value: signSession(testSecret, identity, time.Now()),
+		})
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		require.Equal(http.StatusOK, res.Code)
+
+		return res.Body.String()
+	}
+
+	require.Equal("1.0", post("a", saveCode{
+		Code: "0", Image: testImage, User: "name",
+	}))
+
+	e, err := store.Effect(1)
+	require.NoError(err)
+	require.Equal("name", e.User)
+	require.Equal("a", e.Owner)
+
+	// the user name does not give ownership
+	require.Equal("2.0", post("b", saveCode{
+		CodeID: "1.0", Code: "1", Image: testImage, User: "name",
+	}))
+
+	// the identity does, the user name is kept as sent
+	require.Equal("1.1", post("a", saveCode{
+		CodeID: "1.0", Code: "1", Image: testImage, User: "other name",
+	}))
+
+	e, err = store.Effect(1)
+	require.NoError(err)
+	require.Equal("name", e.User)
+	require.Equal("a", e.Owner)
+
+	// effects without owner are always forked
+	store.Add(&Effect{ID: 10, User: "name", Versions: []Version{{Code: "old"}}})
+	require.Equal("11.0", post("a", saveCode{
+		CodeID: "10.0", Code: "1", Image: testImage, User: "name",
+	}))
+
+	e, err = store.Effect(11)
+	require.NoError(err)
+	require.Equal(uint(10), e.ParentID)
+
+	// the editor gets the owner to know if it can save
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/item/1", nil))
+	require.Equal(http.StatusOK, res.Code)
+
+	var i item
+	require.NoError(json.Unmarshal(res.Body.Bytes(), &i))
+	require.Equal("name", i.User)
+	require.Equal("a", i.Owner)
+}
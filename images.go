@@ -0,0 +1,381 @@
+package glsl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// ErrInvalidImage is returned when the uploaded image is not a valid PNG.
+var ErrInvalidImage = errors.New("invalid image")
+
+// imageSize is a thumbnail size generated for every uploaded image.
+type imageSize struct {
+	name   string
+	width  int
+	height int
+}
+
+var imageSizes = []imageSize{
+	{name: "gallery", width: 200, height: 100},
+	{name: "large", width: 800, height: 400},
+	{name: "social", width: 1200, height: 630},
+}
+
+func findImageSize(name string) (imageSize, bool) {
+	for _, s := range imageSizes {
+		if s.name == name {
+			return s, true
+		}
+	}
+
+	return imageSize{}, false
+}
+
+// imageName returns the file name of the image of an effect. An empty size
+// is the original image.
+func imageName(id uint, size string) string {
+	if size == "" {
+		return fmt.Sprintf("%v.png", id)
+	}
+
+	return fmt.Sprintf("%v-%v.png", id, size)
+}
+
+// decodeImage decodes a base64 PNG image, optionally in data URL format. It
+// returns the decoded image and the PNG bytes.
+func decodeImage(text string) (image.Image, []byte, error) {
+	base := text
+	if i := strings.Index(text, ","); i >= 0 {
+		base = text[i+1:]
+	}
+
+	data, err := base64.StdEncoding.DecodeString(base)
+	if err != nil {
+		return nil, nil, ErrInvalidImage
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, ErrInvalidImage
+	}
+
+	return img, data, nil
+}
+
+// imageDimensions returns the size of a base64 PNG image reading only its
+// header.
+func imageDimensions(text string) (int, int, error) {
+	base := text
+	if i := strings.Index(text, ","); i >= 0 {
+		base = text[i+1:]
+	}
+
+	dec := base64.NewDecoder(base64.StdEncoding, strings.NewReader(base))
+	config, err := png.DecodeConfig(dec)
+	if err != nil {
+		return 0, 0, ErrInvalidImage
+	}
+
+	return config.Width, config.Height, nil
+}
+
+// thumbnail scales the image to fill the given size. The parts that do not
+// fit the aspect ratio are cropped keeping the center.
+func thumbnail(img image.Image, width, height int) image.Image {
+	src := img.Bounds()
+	crop := src
+
+	// compare aspect ratios without divisions
+	if src.Dx()*height > src.Dy()*width {
+		w := src.Dy() * width / height
+		crop.Min.X = src.Min.X + (src.Dx()-w)/2
+		crop.Max.X = crop.Min.X + w
+	} else {
+		h := src.Dx() * height / width
+		crop.Min.Y = src.Min.Y + (src.Dy()-h)/2
+		crop.Max.Y = crop.Min.Y + h
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Src, nil)
+
+	return dst
+}
+
+func encodeImage(img image.Image) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	err := enc.Encode(buf, img)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// stagedImages holds images saved with temporary names until they are
+// committed with the id of their effect. The images they replace are kept
+// until done is called so the commit can be rolled back.
+type stagedImages struct {
+	logger log.Logger
+	images ImageStore
+	// temp maps image sizes to temporary names. The original image has an
+	// empty size.
+	temp map[string]string
+	// renamed maps the committed final names to their temporary ones.
+	renamed map[string]string
+	// previous maps the final names of replaced images to the names they
+	// are kept with.
+	previous map[string]string
+}
+
+// stageImage validates the image sent by the editor and saves it with all its
+// thumbnails using temporary names. The errors are logged with the given
+// logger.
+func stageImage(
+	logger log.Logger,
+	images ImageStore,
+	data saveCode,
+) (*stagedImages, error) {
+	img, original, err := decodeImage(data.Image)
+	if err != nil {
+		logger.Errorf(err, "invalid image")
+		return nil, err
+	}
+
+	return stagePNG(logger, images, img, original)
+}
+
+// stagePNG saves the original image and its thumbnails using temporary
+// names.
+func stagePNG(
+	logger log.Logger,
+	images ImageStore,
+	img image.Image,
+	original []byte,
+) (*stagedImages, error) {
+	files := map[string][]byte{
+		"": original,
+	}
+
+	for _, size := range imageSizes {
+		thumb, err := encodeImage(thumbnail(img, size.width, size.height))
+		if err != nil {
+			logger.Errorf(err, "cannot encode %v thumbnail", size.name)
+			return nil, err
+		}
+
+		files[size.name] = thumb
+	}
+
+	suffix, err := tempSuffix()
+	if err != nil {
+		return nil, err
+	}
+
+	staged := &stagedImages{
+		logger:   logger,
+		images:   images,
+		temp:     make(map[string]string, len(files)),
+		renamed:  make(map[string]string, len(files)),
+		previous: make(map[string]string, len(files)),
+	}
+
+	for size, content := range files {
+		temp := "staged" + suffix
+		if size != "" {
+			temp += "-" + size
+		}
+
+		err = images.Put(temp, content)
+		if err != nil {
+			logger.Errorf(err, "could not save image %v", temp)
+			staged.discard()
+			return nil, err
+		}
+
+		staged.temp[size] = temp
+	}
+
+	return staged, nil
+}
+
+func tempSuffix() (string, error) {
+	random := make([]byte, 8)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+
+	return ".tmp-" + hex.EncodeToString(random), nil
+}
+
+// commit renames the images to the final names for the effect. The images
+// already stored with those names are moved aside. If any rename fails the
+// commit is rolled back.
+func (s *stagedImages) commit(id uint) error {
+	names := make(map[string]string, len(s.temp))
+	final := make([]string, 0, len(s.temp))
+	for size := range s.temp {
+		name := imageName(id, size)
+		names[name] = size
+		final = append(final, name)
+	}
+	sort.Strings(final)
+
+	for _, name := range final {
+		err := s.rename(name, s.temp[names[name]])
+		if err != nil {
+			s.logger.Errorf(err, "could not rename image %v", name)
+			s.rollback()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rename replaces the image name with the temporary one, keeping the
+// previous image if it exists.
+func (s *stagedImages) rename(name, temp string) error {
+	ok, err := s.images.Exists(name)
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		old := temp + ".old"
+		err = s.images.Rename(name, old)
+		if err != nil {
+			return err
+		}
+		s.previous[name] = old
+	}
+
+	err = s.images.Rename(temp, name)
+	if err != nil {
+		return err
+	}
+	s.renamed[name] = temp
+
+	return nil
+}
+
+// rollback moves the committed images back to their temporary names and
+// restores the ones they replaced. The images can be committed again.
+func (s *stagedImages) rollback() {
+	for name, temp := range s.renamed {
+		err := s.images.Rename(name, temp)
+		if err != nil {
+			s.logger.Errorf(err, "could not roll back image %v", name)
+		}
+	}
+	s.renamed = make(map[string]string)
+
+	for name, old := range s.previous {
+		err := s.images.Rename(old, name)
+		if err != nil {
+			s.logger.Errorf(err, "could not restore image %v", name)
+		}
+	}
+	s.previous = make(map[string]string)
+}
+
+// done deletes the images replaced by the commit.
+func (s *stagedImages) done() {
+	for _, old := range s.previous {
+		err := s.images.Delete(old)
+		if err != nil {
+			s.logger.Errorf(err, "could not delete image %v", old)
+		}
+	}
+
+	s.temp = nil
+	s.renamed = nil
+	s.previous = nil
+}
+
+// discard deletes the images not yet committed.
+func (s *stagedImages) discard() {
+	for _, temp := range s.temp {
+		err := s.images.Delete(temp)
+		if err != nil {
+			s.logger.Errorf(err, "could not delete image %v", temp)
+		}
+	}
+	s.temp = nil
+}
+
+// saveImage validates the image sent by the editor and saves it with all its
+// thumbnails.
+func saveImage(
+	logger log.Logger,
+	images ImageStore,
+	id uint,
+	data saveCode,
+) error {
+	staged, err := stageImage(logger, images, data)
+	if err != nil {
+		return err
+	}
+
+	err = staged.commit(id)
+	if err != nil {
+		staged.discard()
+		return err
+	}
+
+	staged.done()
+	return nil
+}
+
+// stageImportImage validates a PNG image read from a dump and saves it with
+// all its thumbnails using temporary names.
+func stageImportImage(
+	logger log.Logger,
+	images ImageStore,
+	data []byte,
+) (*stagedImages, error) {
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width < 1 || config.Height < 1 ||
+		config.Width > maxImageWidth || config.Height > maxImageHeight {
+		return nil, ErrInvalidImage
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	return stagePNG(logger, images, img, data)
+}
+
+// findImage returns the name of the image of an effect with the given size.
+// The original image is used for effects saved before thumbnails were
+// generated.
+func findImage(images ImageStore, id uint, size string) (string, error) {
+	if size != "" {
+		name := imageName(id, size)
+		ok, err := images.Exists(name)
+		if err != nil {
+			return "", err
+		}
+
+		if ok {
+			return name, nil
+		}
+	}
+
+	return imageName(id, ""), nil
+}
@@ -0,0 +1,115 @@
+package glsl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// pngImage returns a data URL with a PNG image of the given size, red on the
+// left half and blue on the right one.
+func pngImage(t *testing.T, width, height int) string {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= width/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, png.Encode(buf, img))
+
+	return "data:image/png;base64," +
+		base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestThumbnail(t *testing.T) {
+	require := require.New(t)
+
+	img, _, err := decodeImage(pngImage(t, 800, 400))
+	require.NoError(err)
+
+	for _, size := range imageSizes {
+		thumb := thumbnail(img, size.width, size.height)
+		require.Equal(size.width, thumb.Bounds().Dx(), size.name)
+		require.Equal(size.height, thumb.Bounds().Dy(), size.name)
+
+		// the image is cropped from the center
+		r, _, b, _ := thumb.At(0, size.height/2).RGBA()
+		require.True(r > b, size.name)
+		r, _, b, _ = thumb.At(size.width-1, size.height/2).RGBA()
+		require.True(b > r, size.name)
+	}
+
+	_, _, err = decodeImage("data:image/png;base64,bm90IGEgcG5n")
+	require.Equal(ErrInvalidImage, err)
+	_, _, err = decodeImage("data:image/png;base64,!!!")
+	require.Equal(ErrInvalidImage, err)
+}
+
+func TestImage(t *testing.T) {
+	require := require.New(t)
+
+	server, _, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	err := saveImage(defaultLogger(), server.images, 1,
+		saveCode{Image: pngImage(t, 800, 400)})
+	require.NoError(err)
+
+	// effects from before thumbnails only have the original image
+	err = server.images.Put("2.png", []byte("legacy"))
+	require.NoError(err)
+
+	tests := []struct {
+		path   string
+		status int
+		width  int
+	}{
+		{path: "/images/1.png", status: http.StatusOK, width: 800},
+		{path: "/images/1.png?size=gallery", status: http.StatusOK, width: 200},
+		{path: "/images/1.png?size=large", status: http.StatusOK, width: 800},
+		{path: "/images/1.png?size=social", status: http.StatusOK, width: 1200},
+		{path: "/images/1.png?size=huge", status: http.StatusNotFound},
+		{path: "/images/2.png?size=gallery", status: http.StatusOK},
+		{path: "/images/3.png", status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", test.path, nil)
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+
+			require.Equal(test.status, res.Code)
+			if test.width == 0 {
+				return
+			}
+
+			require.Equal("image/png", res.Header().Get("Content-Type"))
+			cfg, err := png.DecodeConfig(res.Body)
+			require.NoError(err)
+			require.Equal(test.width, cfg.Width)
+		})
+	}
+
+	err = saveImage(defaultLogger(), server.images, 4, saveCode{Image: "invalid"})
+	require.Equal(ErrInvalidImage, err)
+	ok, err := server.images.Exists("4.png")
+	require.NoError(err)
+	require.False(ok)
+}
@@ -0,0 +1,91 @@
+package glsl
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ImageStore saves and retrieves the images of the effects.
+type ImageStore interface {
+	// Put saves the image with the given name replacing the previous one.
+	Put(name string, data []byte) error
+	// Get opens the image. It returns ErrNotFound if it does not exist.
+	Get(name string) (io.ReadCloser, error)
+	// Exists returns true if the image is stored.
+	Exists(name string) (bool, error)
+	// Rename changes the name of an image replacing the destination if it
+	// exists.
+	Rename(from, to string) error
+	// Delete removes the image. It does not fail if it does not exist.
+	Delete(name string) error
+	// URL returns an address where the client can download the image
+	// directly from the storage. It is empty when the store does not
+	// support it.
+	URL(name string) (string, error)
+}
+
+// FSImageStore is an ImageStore that keeps the images in a local directory.
+type FSImageStore struct {
+	root string
+}
+
+var _ ImageStore = new(FSImageStore)
+
+// NewFSImageStore creates an FSImageStore that saves images in root. The
+// directory is created if it does not exist.
+func NewFSImageStore(root string) (*FSImageStore, error) {
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FSImageStore{root: root}, nil
+}
+
+func (s *FSImageStore) path(name string) string {
+	return filepath.Join(s.root, filepath.Base(name))
+}
+
+func (s *FSImageStore) Put(name string, data []byte) error {
+	return ioutil.WriteFile(s.path(name), data, 0644)
+}
+
+func (s *FSImageStore) Get(name string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return f, err
+}
+
+func (s *FSImageStore) Exists(name string) (bool, error) {
+	_, err := os.Stat(s.path(name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *FSImageStore) Rename(from, to string) error {
+	return os.Rename(s.path(from), s.path(to))
+}
+
+func (s *FSImageStore) Delete(name string) error {
+	err := os.Remove(s.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *FSImageStore) URL(name string) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,225 @@
+package glsl
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSImageStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewFSImageStore(dir + "/images")
+	require.NoError(t, err)
+
+	testImageStore(t, store)
+
+	url, err := store.URL("1.png")
+	require.NoError(t, err)
+	require.Empty(t, url)
+}
+
+// fakeS3 is a minimal in memory S3 service supporting path style put, copy,
+// get, head and delete object requests.
+type fakeS3 struct {
+	m       sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256") &&
+		r.URL.Query().Get("X-Amz-Signature") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			source, _ = url.PathUnescape(source)
+			data, ok := f.objects["/"+source]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+				return
+			}
+
+			f.objects[r.URL.Path] = data
+			w.Write([]byte("<CopyObjectResult></CopyObjectResult>"))
+			return
+		}
+
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		f.objects[r.URL.Path] = data
+	case "DELETE":
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	case "GET", "HEAD":
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == "GET" {
+				w.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+			}
+			return
+		}
+		if r.Method == "GET" {
+			w.Write(data)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func testS3ImageStore(t *testing.T, expiration time.Duration) (*S3ImageStore, *fakeS3, func()) {
+	t.Helper()
+
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+
+	store, err := NewS3ImageStore(S3Options{
+		Bucket:        "bucket",
+		Prefix:        "images",
+		Endpoint:      server.URL,
+		Region:        "us-east-1",
+		AccessKey:     "access",
+		SecretKey:     "secret",
+		PathStyle:     true,
+		URLExpiration: expiration,
+	})
+	require.NoError(t, err)
+
+	return store, fake, server.Close
+}
+
+func TestS3ImageStore(t *testing.T) {
+	require := require.New(t)
+
+	store, fake, cleanup := testS3ImageStore(t, time.Hour)
+	defer cleanup()
+
+	testImageStore(t, store)
+	require.Contains(fake.objects, "/bucket/images/1.png")
+
+	u, err := store.URL("1.png")
+	require.NoError(err)
+	parsed, err := url.Parse(u)
+	require.NoError(err)
+	require.Equal("/bucket/images/1.png", parsed.Path)
+	require.Equal("3600", parsed.Query().Get("X-Amz-Expires"))
+
+	store, _, cleanup = testS3ImageStore(t, 0)
+	defer cleanup()
+
+	u, err = store.URL("1.png")
+	require.NoError(err)
+	require.Empty(u)
+}
+
+func testImageStore(t *testing.T, store ImageStore) {
+	require := require.New(t)
+
+	ok, err := store.Exists("1.png")
+	require.NoError(err)
+	require.False(ok)
+
+	_, err = store.Get("1.png")
+	require.Equal(ErrNotFound, err)
+
+	err = store.Put("1.png", []byte("first"))
+	require.NoError(err)
+	err = store.Put("1.png", []byte("image"))
+	require.NoError(err)
+
+	ok, err = store.Exists("1.png")
+	require.NoError(err)
+	require.True(ok)
+
+	r, err := store.Get("1.png")
+	require.NoError(err)
+	data, err := ioutil.ReadAll(r)
+	require.NoError(err)
+	require.NoError(r.Close())
+	require.Equal("image", string(data))
+
+	err = store.Put("2.png", []byte("renamed"))
+	require.NoError(err)
+	err = store.Rename("2.png", "1.png")
+	require.NoError(err)
+
+	ok, err = store.Exists("2.png")
+	require.NoError(err)
+	require.False(ok)
+
+	r, err = store.Get("1.png")
+	require.NoError(err)
+	data, err = ioutil.ReadAll(r)
+	require.NoError(err)
+	require.NoError(r.Close())
+	require.Equal("renamed", string(data))
+
+	err = store.Delete("1.png")
+	require.NoError(err)
+	err = store.Delete("1.png")
+	require.NoError(err)
+
+	ok, err = store.Exists("1.png")
+	require.NoError(err)
+	require.False(ok)
+
+	err = store.Put("1.png", []byte("image"))
+	require.NoError(err)
+}
+
+func TestImageRedirect(t *testing.T) {
+	require := require.New(t)
+
+	images, _, cleanup := testS3ImageStore(t, time.Hour)
+	defer cleanup()
+
+	err := images.Put("1.png", []byte("original"))
+	require.NoError(err)
+	err = images.Put("2-gallery.png", []byte("thumbnail"))
+	require.NoError(err)
+
+	server := NewServer(NewMemoryStore(), images, false, WithImageRedirect())
+	h := server.router()
+
+	tests := []struct {
+		path     string
+		location string
+	}{
+		{path: "/images/1.png", location: "/bucket/images/1.png"},
+		// images without thumbnails redirect to the original
+		{path: "/images/1.png?size=gallery", location: "/bucket/images/1.png"},
+		{path: "/images/2.png?size=gallery", location: "/bucket/images/2-gallery.png"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+
+		require.Equal(http.StatusFound, res.Code, test.path)
+		location, err := url.Parse(res.Header().Get("Location"))
+		require.NoError(err)
+		require.Equal(test.location, location.Path, test.path)
+	}
+}
@@ -0,0 +1,761 @@
+package glsl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+const (
+	// DefaultImportBatch is the number of effects imported in each
+	// transaction when no other is configured.
+	DefaultImportBatch = 500
+	// DefaultProgressInterval is how often the import progress is logged
+	// when no other interval is configured.
+	DefaultProgressInterval = 10 * time.Second
+	// importQueue is the number of lines per decoder waiting to be decoded
+	// or written.
+	importQueue = 64
+)
+
+// ImportResult is what happened to an effect of the dump.
+type ImportResult int
+
+const (
+	// ImportCreated means the effect did not exist and was created.
+	ImportCreated ImportResult = iota
+	// ImportUpdated means the effect existed and was replaced.
+	ImportUpdated
+	// ImportSkipped means the effect already existed with the same
+	// modification time and number of versions.
+	ImportSkipped
+)
+
+// ImportOptions configures Database.Import.
+type ImportOptions struct {
+	// BatchSize is the number of effects imported in each transaction, by
+	// default DefaultImportBatch.
+	BatchSize int
+	// Checkpoint is the file where the number of lines committed is saved.
+	// If it exists the import continues after them. It is removed when the
+	// import finishes. No checkpoint is used when empty.
+	Checkpoint string
+	// Workers is the number of goroutines decoding effects, by default the
+	// number of CPUs.
+	Workers int
+	// Size is the size of the dump in bytes, used to estimate the time left.
+	// It is not estimated when zero.
+	Size int64
+	// ProgressInterval is how often the progress is logged, by default
+	// DefaultProgressInterval. A negative value disables it.
+	ProgressInterval time.Duration
+	// Thumbnails is where the images referenced by image_url are read from.
+	// They are saved in Images with their thumbnails unless the effect
+	// already has one. Images are not imported when any of them is nil.
+	Thumbnails ThumbnailSource
+	Images     ImageStore
+	// MissingImages receives a JSON line with a MissingImage for each image
+	// that could not be imported.
+	MissingImages io.Writer
+	// KeepImageURL stores the image_url of the dump in the effect.
+	KeepImageURL bool
+}
+
+// MissingImage is an effect whose image could not be imported.
+type MissingImage struct {
+	ID       uint   `json:"id"`
+	ImageURL string `json:"image_url"`
+	Error    string `json:"error"`
+}
+
+// ImportReport counts the effects processed by Database.Import.
+type ImportReport struct {
+	// Resumed is the number of lines skipped from a previous import.
+	Resumed  int
+	Imported int
+	Updated  int
+	Skipped  int
+	// Failed counts the lines that could not be parsed or stored.
+	Failed int
+	// Images is the number of images imported and MissingImages the ones
+	// not found or invalid.
+	Images        int
+	MissingImages int
+}
+
+func (r *ImportReport) add(result ImportResult) {
+	switch result {
+	case ImportCreated:
+		r.Imported++
+	case ImportUpdated:
+		r.Updated++
+	case ImportSkipped:
+		r.Skipped++
+	}
+}
+
+// importRecord is a line of the dump and the effect decoded from it.
+type importRecord struct {
+	line int
+	// begin and offset are the positions in the dump of the start and the
+	// end of the line.
+	begin  int64
+	offset int64
+	data   []byte
+	effect *Effect
+	err    error
+	// imageURL is the image of the effect in the dump, image holds it with
+	// temporary names until the effect is stored and imageErr is set when
+	// it could not be read.
+	imageURL string
+	image    *stagedImages
+	imageErr error
+}
+
+// discardImage deletes the staged image of an effect not stored.
+func (r *importRecord) discardImage() {
+	if r.image != nil {
+		r.image.discard()
+		r.image = nil
+	}
+}
+
+// checkpoint is saved after each committed batch.
+type checkpoint struct {
+	Line int `json:"line"`
+}
+
+// Import reads a dump in the format of LoadEffect and stores its effects.
+// Effects that already exist are updated or skipped if they did not change,
+// so a dump can be imported several times.
+//
+// The lines are read by a goroutine and decoded by a pool of workers. A
+// single writer stores them in the same order as in the dump, each batch in
+// a transaction. When a batch fails its effects are retried one by one so
+// only the wrong ones are lost. The images are read and staged by the
+// workers and only get their final names after their effects are stored.
+func (d *Database) Import(r io.Reader, opts ImportOptions) (*ImportReport, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultImportBatch
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if opts.ProgressInterval == 0 {
+		opts.ProgressInterval = DefaultProgressInterval
+	}
+
+	report := new(ImportReport)
+
+	start, err := readCheckpoint(opts.Checkpoint)
+	if err != nil {
+		return report, err
+	}
+	report.Resumed = start
+
+	var (
+		line   = start
+		offset int64
+		batch  []importRecord
+		// pending holds the decoded lines until the previous ones are
+		// written
+		pending = make(map[int]*importRecord)
+	)
+
+	lines := make(chan *importRecord, opts.Workers*importQueue)
+	decoded := make(chan *importRecord, opts.Workers*importQueue)
+
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+
+		// the images staged for effects not written are not needed
+		for i := range batch {
+			batch[i].discardImage()
+		}
+		for _, rec := range pending {
+			rec.discardImage()
+		}
+		for rec := range decoded {
+			rec.discardImage()
+		}
+	}()
+
+	var readErr error
+	go func() {
+		defer close(lines)
+		readErr = readImportLines(r, start, lines, done)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			decodeImportLines(d.log(), opts, lines, decoded, done)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(decoded)
+	}()
+
+	progress := newImportProgress(d.log(), opts.Size, opts.ProgressInterval)
+
+	flush := func() error {
+		err := d.importBatch(batch, opts, report)
+		if err != nil {
+			return err
+		}
+		batch = batch[:0]
+
+		err = writeCheckpoint(opts.Checkpoint, line)
+		if err != nil {
+			return err
+		}
+
+		progress.update(line-start, offset, false)
+		return nil
+	}
+
+	// the decoded lines are reordered as they are written
+	for rec := range decoded {
+		pending[rec.line] = rec
+
+		for {
+			next, ok := pending[line+1]
+			if !ok {
+				break
+			}
+			delete(pending, next.line)
+			if next.line == start+1 {
+				progress.resume(next.begin)
+			}
+			line, offset = next.line, next.offset
+
+			if next.err != nil {
+				d.log().With(log.Fields{"line": next.line}).
+					Errorf(next.err, "cannot parse effect")
+				report.Failed++
+				continue
+			}
+
+			err = d.reportImage(next, opts, report)
+			if err != nil {
+				next.discardImage()
+				return report, err
+			}
+
+			batch = append(batch, *next)
+			if len(batch) >= opts.BatchSize {
+				err = flush()
+				if err != nil {
+					return report, err
+				}
+			}
+		}
+	}
+
+	if readErr != nil {
+		d.log().With(log.Fields{"line": line + 1}).Errorf(readErr, "cannot read dump")
+		return report, readErr
+	}
+
+	err = flush()
+	if err != nil {
+		return report, err
+	}
+	progress.update(line-start, offset, true)
+
+	if opts.Checkpoint != "" {
+		err = os.Remove(opts.Checkpoint)
+		if err != nil && !os.IsNotExist(err) {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// readImportLines sends the lines of the dump after the first skip ones.
+func readImportLines(
+	r io.Reader,
+	skip int,
+	lines chan<- *importRecord,
+	done <-chan struct{},
+) error {
+	reader := bufio.NewReaderSize(r, 1<<20)
+
+	var (
+		line   int
+		offset int64
+	)
+	for {
+		data, err := reader.ReadBytes('\n')
+		if len(data) > 0 {
+			line++
+			begin := offset
+			offset += int64(len(data))
+
+			if line > skip {
+				rec := &importRecord{
+					line:   line,
+					begin:  begin,
+					offset: offset,
+					data:   bytes.TrimRight(data, "\r\n"),
+				}
+
+				select {
+				case lines <- rec:
+				case <-done:
+					return nil
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// decodeImportLines decodes the effects of the lines and stages their
+// images.
+func decodeImportLines(
+	logger log.Logger,
+	opts ImportOptions,
+	lines <-chan *importRecord,
+	decoded chan<- *importRecord,
+	done <-chan struct{},
+) {
+	for {
+		var (
+			rec *importRecord
+			ok  bool
+		)
+		select {
+		case rec, ok = <-lines:
+			if !ok {
+				return
+			}
+		case <-done:
+			return
+		}
+
+		rec.effect, rec.err = LoadEffect(rec.data)
+		rec.data = nil
+
+		if rec.err == nil {
+			rec.imageURL = rec.effect.ImageURL
+			rec.image, rec.imageErr = stageThumbnail(logger, opts, rec.effect)
+			if !opts.KeepImageURL {
+				rec.effect.ImageURL = ""
+			}
+		}
+
+		select {
+		case decoded <- rec:
+		case <-done:
+			rec.discardImage()
+			return
+		}
+	}
+}
+
+// stageThumbnail saves with temporary names the image referenced by the
+// effect and its thumbnails if it does not already have one. It returns nil
+// if there is no image to import.
+func stageThumbnail(
+	logger log.Logger,
+	opts ImportOptions,
+	e *Effect,
+) (*stagedImages, error) {
+	if opts.Thumbnails == nil || opts.Images == nil || e.ImageURL == "" {
+		return nil, nil
+	}
+
+	ok, err := opts.Images.Exists(imageName(e.ID, ""))
+	if err != nil || ok {
+		return nil, err
+	}
+
+	data, err := opts.Thumbnails.Image(e.ImageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return stageImportImage(logger, opts.Images, data)
+}
+
+// reportImage writes the image of the record to MissingImages if it could
+// not be imported.
+func (d *Database) reportImage(
+	rec *importRecord,
+	opts ImportOptions,
+	report *ImportReport,
+) error {
+	if rec.imageErr == nil {
+		return nil
+	}
+
+	report.MissingImages++
+	d.log().With(log.Fields{"id": rec.effect.ID, "image_url": rec.imageURL}).
+		Warningf("cannot import image: %v", rec.imageErr)
+
+	if opts.MissingImages == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(MissingImage{
+		ID:       rec.effect.ID,
+		ImageURL: rec.imageURL,
+		Error:    rec.imageErr.Error(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = opts.MissingImages.Write(append(data, '\n'))
+	return err
+}
+
+// importProgress logs the number of lines imported, the speed and the
+// estimated time left.
+type importProgress struct {
+	logger   log.Logger
+	size     int64
+	interval time.Duration
+	now      func() time.Time
+	start    time.Time
+	last     time.Time
+	// begin is the position of the first line imported, it is not zero
+	// when resuming.
+	begin int64
+}
+
+func newImportProgress(logger log.Logger, size int64, interval time.Duration) *importProgress {
+	now := time.Now()
+	return &importProgress{
+		logger:   logger,
+		size:     size,
+		interval: interval,
+		now:      time.Now,
+		start:    now,
+		last:     now,
+	}
+}
+
+// resume sets the position of the first line imported so the lines skipped
+// are not counted in the speed.
+func (p *importProgress) resume(begin int64) {
+	p.begin = begin
+}
+
+// update logs the progress if the interval has passed since the last time
+// or final is true. lines is the number of lines processed and offset the
+// position in the dump of the last one.
+func (p *importProgress) update(lines int, offset int64, final bool) {
+	if p.interval < 0 {
+		return
+	}
+
+	now := p.now()
+	if !final && now.Sub(p.last) < p.interval {
+		return
+	}
+	p.last = now
+
+	p.logger.With(p.fields(lines, offset, now)).Infof("import progress")
+}
+
+func (p *importProgress) fields(lines int, offset int64, now time.Time) log.Fields {
+	elapsed := now.Sub(p.start)
+	fields := log.Fields{
+		"lines":   lines,
+		"elapsed": elapsed.Round(time.Second).String(),
+	}
+
+	if elapsed <= 0 {
+		return fields
+	}
+	fields["lines_per_sec"] = int(float64(lines) / elapsed.Seconds())
+
+	if p.size <= 0 || offset <= 0 {
+		return fields
+	}
+	fields["percent"] = int(100 * offset / p.size)
+
+	// the speed is measured in bytes as lines have very different sizes
+	rate := float64(offset-p.begin) / elapsed.Seconds()
+	if rate > 0 {
+		left := time.Duration(float64(p.size-offset) / rate * float64(time.Second))
+		fields["eta"] = left.Round(time.Second).String()
+	}
+
+	return fields
+}
+
+// importBatch imports the effects in a transaction. If it fails they are
+// imported one by one. The staged images are committed after their effects
+// are stored and discarded if they fail.
+func (d *Database) importBatch(
+	batch []importRecord,
+	opts ImportOptions,
+	report *ImportReport,
+) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	results := make([]ImportResult, 0, len(batch))
+	err := d.transaction(func(tx *Database) error {
+		for _, r := range batch {
+			result, err := tx.UpsertEffect(r.effect)
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+		}
+
+		return nil
+	})
+	if err == nil {
+		for _, r := range results {
+			report.add(r)
+		}
+		return d.commitImages(batch, opts, report)
+	}
+
+	if len(batch) == 1 {
+		d.log().With(log.Fields{
+			"line": batch[0].line,
+			"id":   batch[0].effect.ID,
+		}).Errorf(err, "cannot import effect")
+		report.Failed++
+		batch[0].discardImage()
+		return nil
+	}
+
+	for i := range batch {
+		err = d.importBatch(batch[i:i+1], opts, report)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// commitImages gives the final names to the images staged for the stored
+// effects. The ones that cannot be committed are reported as missing.
+func (d *Database) commitImages(
+	batch []importRecord,
+	opts ImportOptions,
+	report *ImportReport,
+) error {
+	var firstErr error
+	for i := range batch {
+		rec := &batch[i]
+		if rec.image == nil {
+			continue
+		}
+
+		err := rec.image.commit(rec.effect.ID)
+		if err != nil {
+			rec.discardImage()
+			rec.imageErr = err
+			err = d.reportImage(rec, opts, report)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		rec.image.done()
+		rec.image = nil
+		report.Images++
+	}
+
+	return firstErr
+}
+
+// ImportEffect stores an effect loaded with LoadEffect keeping its id and
+// indexes it for search.
+func (d *Database) ImportEffect(e *Effect) error {
+	err := d.Create(e).Error
+	if err != nil {
+		d.log().Errorf(err, "cannot import effect %v", e.ID)
+		return err
+	}
+
+	return d.IndexEffect(e)
+}
+
+// UpsertEffect imports the effect, replacing the stored one with the same
+// id unless it has the same modification time, to the second, and number of
+// versions. Skipped effects still get the image url if it is set. The owner
+// of the stored effect is kept if the imported one has none, as old dumps do
+// not have them.
+func (d *Database) UpsertEffect(e *Effect) (ImportResult, error) {
+	// the ids set by a failed attempt are not valid
+	for i := range e.Versions {
+		e.Versions[i].ID = 0
+	}
+
+	var stored Effect
+	err := d.Select("id, modified, owner, image_url").Where("id = ?", e.ID).
+		First(&stored).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return ImportCreated, d.ImportEffect(e)
+	}
+	if err != nil {
+		d.log().Errorf(err, "cannot retrieve effect %v", e.ID)
+		return 0, err
+	}
+
+	var versions int
+	err = d.Model(&Version{}).Where("effect_id = ?", e.ID).Count(&versions).Error
+	if err != nil {
+		d.log().Errorf(err, "cannot count versions of %v", e.ID)
+		return 0, err
+	}
+
+	if sameSecond(stored.Modified, e.Modified) && versions == len(e.Versions) {
+		return ImportSkipped, d.updateImageURL(&stored, e.ImageURL)
+	}
+
+	if e.Owner == "" {
+		e.Owner = stored.Owner
+	}
+
+	columns := map[string]interface{}{
+		"created":        e.Created,
+		"modified":       e.Modified,
+		"parent_id":      e.ParentID,
+		"parent_version": e.ParentVersion,
+		"user":           e.User,
+		"owner":          e.Owner,
+	}
+	if e.ImageURL != "" {
+		columns["image_url"] = e.ImageURL
+	}
+
+	err = d.Model(&Effect{ID: e.ID}).Updates(columns).Error
+	if err != nil {
+		d.log().Errorf(err, "cannot update effect %v", e.ID)
+		return 0, err
+	}
+
+	err = d.Where("effect_id = ?", e.ID).Delete(&Version{}).Error
+	if err != nil {
+		d.log().Errorf(err, "cannot delete versions of %v", e.ID)
+		return 0, err
+	}
+
+	for i := range e.Versions {
+		v := &e.Versions[i]
+		v.EffectID = e.ID
+		err = d.Create(v).Error
+		if err != nil {
+			d.log().Errorf(err, "cannot create version %v of %v", v.Number, e.ID)
+			return 0, err
+		}
+	}
+
+	return ImportUpdated, d.IndexEffect(e)
+}
+
+// sameSecond tells if the times are less than a second apart. MySQL does
+// not keep the fractions of a second, it truncates or rounds them depending
+// on its configuration.
+func sameSecond(a, b time.Time) bool {
+	d := a.Sub(b)
+	return d > -time.Second && d < time.Second
+}
+
+// updateImageURL sets the image_url of an effect that is not updated. The
+// empty url is ignored as it is also used when it is not kept.
+func (d *Database) updateImageURL(stored *Effect, url string) error {
+	if url == "" || url == stored.ImageURL {
+		return nil
+	}
+
+	err := d.Model(&Effect{ID: stored.ID}).Update("image_url", url).Error
+	if err != nil {
+		d.log().Errorf(err, "cannot update image url of %v", stored.ID)
+		return err
+	}
+
+	return nil
+}
+
+// readCheckpoint returns the number of lines already imported.
+func readCheckpoint(path string) (int, error) {
+	if path == "" {
+		return 0, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var c checkpoint
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return 0, fmt.Errorf("invalid checkpoint file %v: %v", path, err)
+	}
+
+	return c.Line, nil
+}
+
+// writeCheckpoint saves the number of lines imported. The file is replaced
+// atomically so it is not corrupted if the import is interrupted.
+func writeCheckpoint(path string, line int) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(checkpoint{Line: line})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,468 @@
+package glsl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testDump returns a dump with effects with ids 1 to n. The user of the
+// effect with id fail is "fail".
+func testDump(t testing.TB, n, fail int) []string {
+	t.Helper()
+
+	base := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	var lines []string
+	for i := 1; i <= n; i++ {
+		user := fmt.Sprintf("user%d", i)
+		if i == fail {
+			user = "fail"
+		}
+
+		data, err := DumpEffect(&Effect{
+			ID:       uint(i),
+			Created:  base,
+			Modified: base.Add(time.Duration(i) * time.Minute),
+			User:     user,
+			Versions: []Version{
+				{Created: base, Code: fmt.Sprintf("first%d", i)},
+				{Created: base, Code: fmt.Sprintf("second%d", i)},
+			},
+		})
+		require.NoError(t, err)
+		lines = append(lines, string(data))
+	}
+
+	return lines
+}
+
+func dumpReader(lines []string) io.Reader {
+	return strings.NewReader(strings.Join(lines, "\n") + "\n")
+}
+
+func TestImport(t *testing.T) {
+	require := require.New(t)
+
+	db := migratedDatabase(t)
+	defer db.Close()
+
+	// effects of the user "fail" cannot be stored
+	require.NoError(db.Exec(`CREATE TRIGGER fail BEFORE INSERT ON effects
+		WHEN NEW.user = 'fail' BEGIN SELECT RAISE(ABORT, 'fail'); END`).Error)
+
+	lines := testDump(t, 5, 4)
+	lines = append(lines[:2], append([]string{"{invalid"}, lines[2:]...)...)
+
+	report, err := db.Import(dumpReader(lines), ImportOptions{BatchSize: 2})
+	require.NoError(err)
+	require.Equal(&ImportReport{Imported: 4, Failed: 2}, report)
+
+	count, err := db.EffectCount()
+	require.NoError(err)
+	require.Equal(4, count)
+
+	_, err = db.Effect(4)
+	require.Equal(ErrNotFound, err)
+
+	e, err := db.Effect(5)
+	require.NoError(err)
+	require.Len(e.Versions, 2)
+	require.Equal("second5", e.Versions[1].Code)
+
+	// the same dump can be imported again
+	report, err = db.Import(dumpReader(lines), ImportOptions{BatchSize: 2})
+	require.NoError(err)
+	require.Equal(&ImportReport{Skipped: 4, Failed: 2}, report)
+
+	count, err = db.VersionCount()
+	require.NoError(err)
+	require.Equal(8, count)
+
+	// MySQL does not keep fractions of a second of the dumped times
+	stored, err := LoadEffect([]byte(lines[1]))
+	require.NoError(err)
+	require.NoError(db.Model(&Effect{ID: stored.ID}).
+		Update("modified", stored.Modified.Add(-600*time.Millisecond)).Error)
+	report, err = db.Import(dumpReader(lines), ImportOptions{BatchSize: 2})
+	require.NoError(err)
+	require.Equal(&ImportReport{Skipped: 4, Failed: 2}, report)
+
+	// changed effects replace the stored ones
+	changed, err := LoadEffect([]byte(lines[0]))
+	require.NoError(err)
+	changed.Modified = changed.Modified.Add(time.Hour)
+	changed.User = "changed"
+	changed.Versions = append(changed.Versions, Version{
+		Number:  2,
+		Created: changed.Modified,
+		Code:    "third",
+	})
+	require.NoError(db.Model(&Effect{ID: 1}).Update("owner", "owner1").Error)
+
+	data, err := DumpEffect(changed)
+	require.NoError(err)
+	lines[0] = string(data)
+
+	report, err = db.Import(dumpReader(lines), ImportOptions{})
+	require.NoError(err)
+	require.Equal(&ImportReport{Updated: 1, Skipped: 3, Failed: 2}, report)
+
+	e, err = db.Effect(1)
+	require.NoError(err)
+	require.Equal("changed", e.User)
+	require.Equal("owner1", e.Owner)
+	require.True(changed.Modified.Equal(e.Modified))
+	require.Len(e.Versions, 3)
+	require.Equal("third", e.Versions[2].Code)
+
+	results, _, err := db.Search("third", 0, 10)
+	require.NoError(err)
+	require.Len(results, 1)
+	results, _, err = db.Search("user1", 0, 10)
+	require.NoError(err)
+	require.Len(results, 0)
+
+	count, err = db.VersionCount()
+	require.NoError(err)
+	require.Equal(9, count)
+}
+
+func TestImportOrder(t *testing.T) {
+	require := require.New(t)
+
+	db := migratedDatabase(t)
+	defer db.Close()
+
+	// the last line with the same id is the one stored even when decoded
+	// by several workers
+	lines := testDump(t, 300, 0)
+	last, err := LoadEffect([]byte(lines[0]))
+	require.NoError(err)
+	last.Modified = last.Modified.Add(time.Hour)
+	last.User = "last"
+	data, err := DumpEffect(last)
+	require.NoError(err)
+	lines = append(lines, string(data))
+
+	report, err := db.Import(dumpReader(lines), ImportOptions{
+		BatchSize: 7,
+		Workers:   8,
+	})
+	require.NoError(err)
+	require.Equal(&ImportReport{Imported: 300, Updated: 1}, report)
+
+	e, err := db.Effect(1)
+	require.NoError(err)
+	require.Equal("last", e.User)
+}
+
+func TestImportProgress(t *testing.T) {
+	require := require.New(t)
+
+	start := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	p := newImportProgress(nil, 1000, time.Second)
+	p.start = start
+	p.resume(200)
+
+	fields := p.fields(50, 400, start.Add(10*time.Second))
+	require.Equal(5, fields["lines_per_sec"])
+	require.Equal(40, fields["percent"])
+	require.Equal("10s", fields["elapsed"])
+	// 200 bytes in 10 seconds, 600 left
+	require.Equal("30s", fields["eta"])
+
+	// no estimation without the size
+	p = newImportProgress(nil, 0, time.Second)
+	p.start = start
+	fields = p.fields(50, 400, start.Add(10*time.Second))
+	require.Equal(5, fields["lines_per_sec"])
+	require.NotContains(fields, "percent")
+	require.NotContains(fields, "eta")
+}
+
+func TestImportImages(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	images, err := NewFSImageStore(filepath.Join(dir, "images"))
+	require.NoError(err)
+
+	_, valid, err := decodeImage(pngImage(t, 400, 200))
+	require.NoError(err)
+
+	thumbs := filepath.Join(dir, "thumbs")
+	require.NoError(os.MkdirAll(thumbs, 0755))
+	require.NoError(ioutil.WriteFile(
+		filepath.Join(thumbs, "1.png"), valid, 0644))
+	require.NoError(ioutil.WriteFile(
+		filepath.Join(thumbs, "3.png"), []byte("invalid"), 0644))
+	require.NoError(ioutil.WriteFile(
+		filepath.Join(thumbs, "4.png"), valid, 0644))
+
+	// the image of 4 is already stored
+	require.NoError(images.Put("4.png", []byte("stored")))
+
+	var lines []string
+	for _, line := range testDump(t, 5, 0) {
+		e, err := LoadEffect([]byte(line))
+		require.NoError(err)
+		if e.ID != 5 {
+			e.ImageURL = fmt.Sprintf("thumbs/%d.png", e.ID)
+		}
+
+		data, err := DumpEffect(e)
+		require.NoError(err)
+		lines = append(lines, string(data))
+	}
+
+	source, err := OpenThumbnails(dir)
+	require.NoError(err)
+	defer source.Close()
+
+	db := migratedDatabase(t)
+	defer db.Close()
+
+	var missing bytes.Buffer
+	opts := ImportOptions{
+		Thumbnails:    source,
+		Images:        images,
+		MissingImages: &missing,
+	}
+	report, err := db.Import(dumpReader(lines), opts)
+	require.NoError(err)
+	require.Equal(&ImportReport{Imported: 5, Images: 1, MissingImages: 2}, report)
+
+	require.Equal(
+		`{"id":2,"image_url":"thumbs/2.png","error":"not found"}`+"\n"+
+			`{"id":3,"image_url":"thumbs/3.png","error":"invalid image"}`+"\n",
+		missing.String())
+
+	for _, name := range []string{"1.png", "1-gallery.png", "1-large.png", "1-social.png"} {
+		ok, err := images.Exists(name)
+		require.NoError(err)
+		require.True(ok, name)
+	}
+
+	for _, name := range []string{"2.png", "3.png", "5.png", "4-gallery.png"} {
+		ok, err := images.Exists(name)
+		require.NoError(err)
+		require.False(ok, name)
+	}
+
+	e, err := db.Effect(1)
+	require.NoError(err)
+	require.Empty(e.ImageURL)
+
+	// image_url is stored when requested
+	first := db
+	db = migratedDatabase(t)
+	defer db.Close()
+
+	opts.KeepImageURL = true
+	opts.MissingImages = nil
+	report, err = db.Import(dumpReader(lines), opts)
+	require.NoError(err)
+	require.Equal(&ImportReport{Imported: 5, MissingImages: 2}, report)
+
+	e, err = db.Effect(1)
+	require.NoError(err)
+	require.Equal("thumbs/1.png", e.ImageURL)
+	e, err = db.Effect(5)
+	require.NoError(err)
+	require.Empty(e.ImageURL)
+
+	// and also for effects skipped as they did not change
+	report, err = first.Import(dumpReader(lines), opts)
+	require.NoError(err)
+	require.Equal(&ImportReport{Skipped: 5, MissingImages: 2}, report)
+
+	e, err = first.Effect(1)
+	require.NoError(err)
+	require.Equal("thumbs/1.png", e.ImageURL)
+}
+
+func TestImportImagesFailure(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	imagesDir := filepath.Join(dir, "images")
+	images, err := NewFSImageStore(imagesDir)
+	require.NoError(err)
+
+	_, valid, err := decodeImage(pngImage(t, 400, 200))
+	require.NoError(err)
+
+	thumbs := filepath.Join(dir, "thumbs")
+	require.NoError(os.MkdirAll(thumbs, 0755))
+
+	var lines []string
+	for _, line := range testDump(t, 4, 2) {
+		e, err := LoadEffect([]byte(line))
+		require.NoError(err)
+		e.ImageURL = fmt.Sprintf("thumbs/%d.png", e.ID)
+		require.NoError(ioutil.WriteFile(
+			filepath.Join(thumbs, fmt.Sprintf("%d.png", e.ID)), valid, 0644))
+
+		data, err := DumpEffect(e)
+		require.NoError(err)
+		lines = append(lines, string(data))
+	}
+
+	source, err := OpenThumbnails(dir)
+	require.NoError(err)
+	defer source.Close()
+
+	db := migratedDatabase(t)
+	defer db.Close()
+
+	require.NoError(db.Exec(`CREATE TRIGGER fail BEFORE INSERT ON effects
+		WHEN NEW.user = 'fail' BEGIN SELECT RAISE(ABORT, 'fail'); END`).Error)
+
+	opts := ImportOptions{
+		BatchSize:  2,
+		Thumbnails: source,
+		Images:     images,
+	}
+
+	// the images of a failed batch are only kept for the stored effects
+	report, err := db.Import(dumpReader(lines), opts)
+	require.NoError(err)
+	require.Equal(&ImportReport{Imported: 3, Failed: 1, Images: 3}, report)
+
+	var expected []string
+	for _, id := range []uint{1, 3, 4} {
+		expected = append(expected, imageName(id, ""))
+		for _, size := range imageSizes {
+			expected = append(expected, imageName(id, size.name))
+		}
+	}
+	require.ElementsMatch(expected, listDir(t, imagesDir))
+
+	// the staged images are deleted when the import stops
+	db = migratedDatabase(t)
+	defer db.Close()
+	require.NoError(os.RemoveAll(imagesDir))
+	images, err = NewFSImageStore(imagesDir)
+	require.NoError(err)
+	opts.Images = images
+
+	partial := strings.Join(lines[:3], "\n") + "\n"
+	_, err = db.Import(&failingReader{r: bytes.NewBufferString(partial)}, opts)
+	require.Equal(errRead, err)
+
+	expected = nil
+	for _, id := range []uint{1, 2} {
+		expected = append(expected, imageName(id, ""))
+		for _, size := range imageSizes {
+			expected = append(expected, imageName(id, size.name))
+		}
+	}
+	require.ElementsMatch(expected, listDir(t, imagesDir))
+}
+
+// failingReader returns an error after reading all the data of r.
+type failingReader struct {
+	r io.Reader
+}
+
+var errRead = errors.New("read error")
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errRead
+	}
+
+	return n, err
+}
+
+func TestImportCheckpoint(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	checkpoint := filepath.Join(dir, "dump.checkpoint")
+
+	db := migratedDatabase(t)
+	defer db.Close()
+
+	lines := testDump(t, 7, 0)
+	opts := ImportOptions{BatchSize: 3, Checkpoint: checkpoint}
+
+	// interrupted in the middle of the second batch
+	partial := strings.Join(lines[:5], "\n") + "\n"
+	report, err := db.Import(
+		&failingReader{r: bytes.NewBufferString(partial)}, opts)
+	require.Equal(errRead, err)
+	require.Equal(&ImportReport{Imported: 3}, report)
+
+	data, err := ioutil.ReadFile(checkpoint)
+	require.NoError(err)
+	require.JSONEq(`{"line": 3}`, string(data))
+
+	count, err := db.EffectCount()
+	require.NoError(err)
+	require.Equal(3, count)
+
+	report, err = db.Import(dumpReader(lines), opts)
+	require.NoError(err)
+	require.Equal(&ImportReport{Resumed: 3, Imported: 4}, report)
+
+	count, err = db.EffectCount()
+	require.NoError(err)
+	require.Equal(7, count)
+
+	_, err = os.Stat(checkpoint)
+	require.True(os.IsNotExist(err))
+
+	require.NoError(ioutil.WriteFile(checkpoint, []byte("invalid"), 0644))
+	_, err = db.Import(dumpReader(lines), opts)
+	require.Error(err)
+}
+
+func BenchmarkImport(b *testing.B) {
+	lines := testDump(b, 2000, 0)
+	dump := []byte(strings.Join(lines, "\n") + "\n")
+
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(dump)))
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				db := migratedDatabase(b)
+				b.StartTimer()
+
+				report, err := db.Import(bytes.NewReader(dump), ImportOptions{
+					Workers:          workers,
+					ProgressInterval: -1,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if report.Imported != len(lines) {
+					b.Fatalf("imported %d effects", report.Imported)
+				}
+
+				b.StopTimer()
+				db.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
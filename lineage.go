@@ -0,0 +1,118 @@
+package glsl
+
+// maxDescendants limits the number of effects loaded by Descendants.
+const maxDescendants = 1000
+
+// Lineage is the fork history of an effect.
+type Lineage struct {
+	// Ancestors holds the effects the effect was forked from, starting with
+	// the root and ending with its parent.
+	Ancestors []Effect
+	// Tree holds the effect and all the effects forked from it.
+	Tree *LineageNode
+	// Truncated is true when not all the descendants could be loaded.
+	Truncated bool
+}
+
+// LineageNode is an effect in a fork tree.
+type LineageNode struct {
+	Effect   Effect
+	Children []*LineageNode
+}
+
+// LoadLineage retrieves the ancestors and descendants of an effect.
+func LoadLineage(db Store, id int) (*Lineage, error) {
+	ancestors, err := Ancestors(db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, truncated, err := Descendants(db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Lineage{
+		Ancestors: ancestors,
+		Tree:      tree,
+		Truncated: truncated,
+	}, nil
+}
+
+// Ancestors returns the chain of effects the effect was forked from, starting
+// with the root. The chain stops at the first parent that does not exist. The
+// versions do not include the code.
+func Ancestors(db Store, id int) ([]Effect, error) {
+	effect, err := db.Effect(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var ancestors []Effect
+	visited := map[uint]bool{effect.ID: true}
+	for effect.ParentID != 0 && !visited[effect.ParentID] {
+		visited[effect.ParentID] = true
+
+		effect, err = db.Effect(int(effect.ParentID))
+		if err == ErrNotFound {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		clearCode(effect)
+		ancestors = append([]Effect{*effect}, ancestors...)
+	}
+
+	return ancestors, nil
+}
+
+// Descendants returns the tree of effects forked from the effect, level by
+// level. It returns true when the tree was truncated at maxDescendants
+// effects. The versions do not include the code.
+func Descendants(db Store, id int) (*LineageNode, bool, error) {
+	effect, err := db.Effect(id)
+	if err != nil {
+		return nil, false, err
+	}
+	clearCode(effect)
+
+	root := &LineageNode{Effect: *effect}
+	nodes := map[uint]*LineageNode{effect.ID: root}
+	level := []uint{effect.ID}
+	count := 0
+
+	for len(level) > 0 {
+		children, err := db.Children(level...)
+		if err != nil {
+			return nil, false, err
+		}
+
+		level = nil
+		for _, c := range children {
+			if _, ok := nodes[c.ID]; ok {
+				continue
+			}
+
+			if count >= maxDescendants {
+				return root, true, nil
+			}
+			count++
+
+			node := &LineageNode{Effect: c}
+			nodes[c.ID] = node
+			parent := nodes[c.ParentID]
+			parent.Children = append(parent.Children, node)
+			level = append(level, c.ID)
+		}
+	}
+
+	return root, false, nil
+}
+
+func clearCode(e *Effect) {
+	for i := range e.Versions {
+		e.Versions[i].Code = ""
+	}
+}
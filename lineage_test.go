@@ -0,0 +1,125 @@
+package glsl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// lineageStore creates this fork tree:
+//
+//	1 -> 2 -> 3 -> 5
+//	          3 -> 6
+//	     2 -> 4
+//	7
+func lineageStore() *MemoryStore {
+	store := NewMemoryStore()
+	parents := []struct{ id, parent uint }{
+		{1, 0}, {2, 1}, {3, 2}, {4, 2}, {5, 3}, {6, 3}, {7, 0},
+	}
+
+	for _, p := range parents {
+		store.Add(&Effect{
+			ID:            p.id,
+			ParentID:      p.parent,
+			ParentVersion: 1,
+			Versions: []Version{
+				{Number: 0, Code: "first"},
+				{Number: 1, Code: "second"},
+			},
+		})
+	}
+
+	return store
+}
+
+func nodeIDs(nodes []*LineageNode) []uint {
+	var ids []uint
+	for _, n := range nodes {
+		ids = append(ids, n.Effect.ID)
+	}
+	return ids
+}
+
+func TestLineage(t *testing.T) {
+	require := require.New(t)
+	store := lineageStore()
+
+	lineage, err := LoadLineage(store, 3)
+	require.NoError(err)
+	require.False(lineage.Truncated)
+
+	require.Len(lineage.Ancestors, 2)
+	require.Equal(uint(1), lineage.Ancestors[0].ID)
+	require.Equal(uint(2), lineage.Ancestors[1].ID)
+	require.Empty(lineage.Ancestors[0].Versions[0].Code)
+
+	tree := lineage.Tree
+	require.Equal(uint(3), tree.Effect.ID)
+	require.Equal([]uint{5, 6}, nodeIDs(tree.Children))
+	require.Empty(tree.Children[0].Children)
+
+	lineage, err = LoadLineage(store, 1)
+	require.NoError(err)
+	require.Empty(lineage.Ancestors)
+	require.Equal([]uint{2}, nodeIDs(lineage.Tree.Children))
+	require.Equal([]uint{3, 4}, nodeIDs(lineage.Tree.Children[0].Children))
+
+	lineage, err = LoadLineage(store, 7)
+	require.NoError(err)
+	require.Empty(lineage.Ancestors)
+	require.Empty(lineage.Tree.Children)
+
+	_, err = LoadLineage(store, 8)
+	require.Equal(ErrNotFound, err)
+}
+
+func TestLineageMissingParent(t *testing.T) {
+	require := require.New(t)
+
+	store := NewMemoryStore()
+	store.Add(&Effect{ID: 2, ParentID: 1})
+
+	ancestors, err := Ancestors(store, 2)
+	require.NoError(err)
+	require.Empty(ancestors)
+}
+
+func TestTreeHandlers(t *testing.T) {
+	require := require.New(t)
+
+	server := NewServer(lineageStore(), nil, false)
+	h := server.router()
+
+	var lineage apiLineage
+	status := apiRequest(t, h, "GET", "/api/v1/effects/2/tree", nil, &lineage)
+	require.Equal(http.StatusOK, status)
+	require.Len(lineage.Ancestors, 1)
+	require.Equal(uint(1), lineage.Ancestors[0].ID)
+	require.Equal(uint(2), lineage.Tree.ID)
+	require.Len(lineage.Tree.Children, 2)
+	require.Equal(uint(3), lineage.Tree.Children[0].ID)
+	require.Equal(&apiParent{ID: 2, Version: 1}, lineage.Tree.Children[0].Parent)
+	require.Len(lineage.Tree.Children[0].Children, 2)
+
+	var apiErr apiError
+	status = apiRequest(t, h, "GET", "/api/v1/effects/8/tree", nil, &apiErr)
+	require.Equal(http.StatusNotFound, status)
+
+	req := httptest.NewRequest("GET", "/tree/2", nil)
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	require.Equal(http.StatusOK, res.Code)
+	body := res.Body.String()
+	for _, img := range []string{"1", "2", "3", "4", "5", "6"} {
+		require.Contains(body, "/images/"+img+".png")
+	}
+	require.NotContains(body, "/images/7.png")
+
+	req = httptest.NewRequest("GET", "/tree/8", nil)
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	require.Equal(http.StatusNotFound, res.Code)
+}
@@ -0,0 +1,140 @@
+package glsl
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDSize limits the size of request ids sent by clients.
+	maxRequestIDSize = 128
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	loggerKey
+	identityKey
+)
+
+// WithLogger sets the logger used as base for the request loggers, by default
+// the one of the package level log functions.
+func WithLogger(logger log.Logger) ServerOption {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// requestID assigns an id to each request, taken from the X-Request-ID
+// header if the client sent a valid one. The id is sent back in the same
+// header and added to the request logger.
+func (s *Server) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+
+		base := s.logger
+		if base == nil {
+			base = defaultLogger()
+		}
+
+		logger := base.New(log.Fields{"request_id": id})
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		ctx = context.WithValue(ctx, loggerKey, logger)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID accepts non empty ids of printable ascii characters so
+// clients cannot inject anything odd in the logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDSize {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Errorf(err, "cannot generate request id")
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// logRequests logs every request once it is served with its route, status,
+// size and duration.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		requestLogger(r).With(log.Fields{
+			"method":   r.Method,
+			"path":     r.URL.Path,
+			"route":    routePattern(r),
+			"status":   status,
+			"bytes":    ww.BytesWritten(),
+			"duration": time.Since(start),
+		}).Infof("request served")
+	})
+}
+
+// RequestID returns the id assigned to the request or an empty string if it
+// has none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// requestLogger returns the logger of the request, carrying its id, or the
+// default logger if it has none.
+func requestLogger(r *http.Request) log.Logger {
+	logger, ok := r.Context().Value(loggerKey).(log.Logger)
+	if !ok {
+		return defaultLogger()
+	}
+
+	return logger
+}
+
+// defaultLogger returns the logger used by the package level log functions.
+func defaultLogger() log.Logger {
+	if log.DefaultLogger != nil {
+		return log.DefaultLogger
+	}
+
+	return log.New(nil)
+}
+
+// store returns the Store that logs with the request logger.
+func (s *Server) store(r *http.Request) Store {
+	return s.db.WithLogger(requestLogger(r))
+}
@@ -0,0 +1,167 @@
+package glsl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+type logEntry struct {
+	level   string
+	message string
+	err     error
+	fields  log.Fields
+}
+
+// recordLogger is a log.Logger that keeps the entries in memory.
+type recordLogger struct {
+	m       *sync.Mutex
+	entries *[]logEntry
+	fields  log.Fields
+}
+
+func newRecordLogger() *recordLogger {
+	return &recordLogger{
+		m:       new(sync.Mutex),
+		entries: new([]logEntry),
+		fields:  log.Fields{},
+	}
+}
+
+func (l *recordLogger) New(f log.Fields) log.Logger {
+	fields := log.Fields{}
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	for k, v := range f {
+		fields[k] = v
+	}
+
+	return &recordLogger{m: l.m, entries: l.entries, fields: fields}
+}
+
+func (l *recordLogger) With(f log.Fields) log.Logger {
+	return l.New(f)
+}
+
+func (l *recordLogger) add(level string, err error, format string, args []interface{}) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	*l.entries = append(*l.entries, logEntry{
+		level:   level,
+		message: fmt.Sprintf(format, args...),
+		err:     err,
+		fields:  l.fields,
+	})
+}
+
+func (l *recordLogger) Debugf(format string, args ...interface{}) {
+	l.add("debug", nil, format, args)
+}
+
+func (l *recordLogger) Infof(format string, args ...interface{}) {
+	l.add("info", nil, format, args)
+}
+
+func (l *recordLogger) Warningf(format string, args ...interface{}) {
+	l.add("warning", nil, format, args)
+}
+
+func (l *recordLogger) Errorf(err error, format string, args ...interface{}) {
+	l.add("error", err, format, args)
+}
+
+func (l *recordLogger) find(message string) (logEntry, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	for _, e := range *l.entries {
+		if e.message == message {
+			return e, true
+		}
+	}
+
+	return logEntry{}, false
+}
+
+func TestRequestID(t *testing.T) {
+	require := require.New(t)
+
+	server, _, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	get := func(id string) string {
+		req := httptest.NewRequest("GET", "/healthz", nil)
+		if id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		require.Equal(http.StatusOK, res.Code)
+		return res.Header().Get(requestIDHeader)
+	}
+
+	id1 := get("")
+	id2 := get("")
+	require.Len(id1, 32)
+	require.NotEqual(id1, id2)
+
+	require.Equal("client-id.1", get("client-id.1"))
+
+	for _, invalid := range []string{"with space", "new\nline", string(make([]byte, 200))} {
+		id := get(invalid)
+		require.NotEqual(invalid, id)
+		require.Len(id, 32)
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	require := require.New(t)
+
+	// the schema is not created so queries fail
+	db := testDatabase(t)
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	images, err := NewFSImageStore(dir)
+	require.NoError(err)
+
+	logger := newRecordLogger()
+	server := NewServer(db, images, false, WithLogger(logger))
+
+	req := httptest.NewRequest("GET", "/item/1", nil)
+	req.Header.Set(requestIDHeader, "request-1")
+	res := httptest.NewRecorder()
+	server.router().ServeHTTP(res, req)
+	require.Equal(http.StatusNotFound, res.Code)
+
+	e, ok := logger.find("cannot retrieve effect 1")
+	require.True(ok)
+	require.Equal("error", e.level)
+	require.Error(e.err)
+	require.Equal("request-1", e.fields["request_id"])
+
+	e, ok = logger.find("request served")
+	require.True(ok)
+	require.Equal("info", e.level)
+	require.Equal("request-1", e.fields["request_id"])
+	require.Equal("GET", e.fields["method"])
+	require.Equal("/item/1", e.fields["path"])
+	require.Equal("/item/{effect:[0-9]+}", e.fields["route"])
+	require.Equal(http.StatusNotFound, e.fields["status"])
+	require.Equal(res.Body.Len(), e.fields["bytes"])
+	require.Contains(e.fields, "duration")
+}
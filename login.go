@@ -0,0 +1,270 @@
+package glsl
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	loginPath = "/assets/login.html"
+	// csrfField is the form field with the CSRF token.
+	csrfField = "csrf"
+	// maxFormSize is the maximum size of the login forms.
+	maxFormSize = 64 << 10
+)
+
+// LoginPage is the data used to render the login template.
+type LoginPage struct {
+	// Account is the logged in account or nil.
+	Account *Account
+	// Providers are the names of the OAuth providers.
+	Providers []string
+	// CSRF is the token the forms must send.
+	CSRF  string
+	Name  string
+	Error string
+}
+
+func (s *Server) loginPage(w http.ResponseWriter, r *http.Request) {
+	s.renderLogin(w, r, http.StatusOK, LoginPage{})
+}
+
+// renderLogin shows the login page, filling the account and providers.
+func (s *Server) renderLogin(
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	page LoginPage,
+) {
+	logger := requestLogger(r)
+
+	tmpl, err := loadTemplate(logger, s.fs, loginPath)
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	account, err := s.store(r).AccountByIdentity(requestIdentity(r))
+	if err != nil && err != ErrNotFound {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	page.Account = account
+	page.CSRF = csrfToken(s.sessionSecret, requestIdentity(r))
+
+	for name := range s.oauthProviders {
+		page.Providers = append(page.Providers, name)
+	}
+	sort.Strings(page.Providers)
+
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, page)
+	if err != nil {
+		logger.Errorf(err, "cannot render template %v", loginPath)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, err = w.Write(buf.Bytes())
+	if err != nil {
+		logger.Errorf(err, "cannot write page")
+	}
+}
+
+// checkCSRF is a middleware that rejects the forms that do not have the
+// CSRF token of the client, as they may have been sent by other site.
+func (s *Server) checkCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, http.StatusText(400), 400)
+			return
+		}
+
+		token := r.PostForm.Get(csrfField)
+		expected := csrfToken(s.sessionSecret, requestIdentity(r))
+		if !hmac.Equal([]byte(token), []byte(expected)) {
+			requestLogger(r).Warningf("invalid csrf token")
+			http.Error(w, "invalid form, reload the page and try again",
+				http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readLoginForm returns the account name, in lower case, the password and if
+// the effects of the client have to be claimed.
+func readLoginForm(w http.ResponseWriter, r *http.Request) (string, string, bool, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+	err := r.ParseForm()
+	if err != nil {
+		return "", "", false, err
+	}
+
+	name := strings.ToLower(strings.TrimSpace(r.PostForm.Get("name")))
+	return name, r.PostForm.Get("password"), r.PostForm.Get("claim") != "", nil
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	name, password, claim, err := readLoginForm(w, r)
+	if err != nil {
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+
+	account, err := checkPassword(s.store(r), name, password)
+	if err == ErrInvalidLogin {
+		s.renderLogin(w, r, http.StatusUnauthorized, LoginPage{
+			Name:  name,
+			Error: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	s.logIn(w, r, account, claim)
+}
+
+func (s *Server) signup(w http.ResponseWriter, r *http.Request) {
+	name, password, claim, err := readLoginForm(w, r)
+	if err != nil {
+		http.Error(w, http.StatusText(400), 400)
+		return
+	}
+
+	account, err := s.createAccount(r, name, password)
+	if e, ok := err.(*requestError); ok {
+		s.renderLogin(w, r, e.Status, LoginPage{
+			Name:  name,
+			Error: e.Message,
+		})
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	s.logIn(w, r, account, claim)
+}
+
+// createAccount creates a local account. Invalid data is returned as a
+// requestError.
+func (s *Server) createAccount(r *http.Request, name, password string) (*Account, error) {
+	err := validAccountName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if password == "" {
+		return nil, invalidField("password", "password is required")
+	}
+
+	account, err := newAccount(name, password)
+	if err != nil {
+		if _, ok := err.(*requestError); !ok {
+			requestLogger(r).Errorf(err, "cannot create account")
+		}
+		return nil, err
+	}
+
+	err = s.store(r).NewAccount(account)
+	if err == ErrConflict {
+		return nil, &requestError{
+			Status:  http.StatusConflict,
+			Field:   "name",
+			Message: "name already used",
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// logIn makes the identity of the account the one of the client and sends
+// it to its gallery. If claim is true the effects of the anonymous identity
+// of the client are moved to the account. The effects of other accounts
+// cannot be claimed.
+func (s *Server) logIn(
+	w http.ResponseWriter,
+	r *http.Request,
+	account *Account,
+	claim bool,
+) {
+	db := s.store(r)
+	logger := requestLogger(r)
+
+	current := requestIdentity(r)
+	if claim && current != "" && current != account.Identity {
+		_, err := db.AccountByIdentity(current)
+		switch err {
+		case ErrNotFound:
+			n, err := db.ClaimEffects(current, account.Identity)
+			if err != nil {
+				http.Error(w, http.StatusText(500), 500)
+				return
+			}
+			logger.Infof("account %v claimed %v effects", account.Name, n)
+		case nil:
+		default:
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+	}
+
+	s.setSessionCookies(w, r, account.Identity)
+	http.Redirect(w, r, "/u/"+url.PathEscape(account.Name), http.StatusSeeOther)
+}
+
+// logout gives the client a new anonymous identity.
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	id, err := newIdentity()
+	if err != nil {
+		requestLogger(r).Errorf(err, "cannot create identity")
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	s.setSessionCookies(w, r, id)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// userGallery shows the effects of an account.
+func (s *Server) userGallery(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+
+	account, err := s.store(r).Account(name)
+	if err == ErrNotFound {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	gallery := Gallery{
+		Path:    "/u/" + account.Name,
+		Account: account.Name,
+	}
+	s.renderGallery(w, r, gallery, func(db Store, page int) ([]Effect, error) {
+		return db.OwnerEffects(account.Identity, page, perPage)
+	})
+}
@@ -0,0 +1,429 @@
+package glsl
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// MemoryStore is a Store that keeps effects in memory. It is meant to be used
+// in tests. Transactions are serialized and changes done by other goroutines
+// while a transaction is running are lost if it is rolled back.
+type MemoryStore struct {
+	tx      sync.Mutex
+	m       sync.RWMutex
+	effects map[uint]*Effect
+	lastID  uint
+	lastVer uint
+
+	accounts    []Account
+	logins      []AccountLogin
+	lastAccount uint
+}
+
+var _ Store = new(MemoryStore)
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		effects: make(map[uint]*Effect),
+	}
+}
+
+// Add stores a copy of an already built effect, keeping its ID. It can be
+// used to populate the store.
+func (m *MemoryStore) Add(e *Effect) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	c := copyEffect(e)
+	m.effects[c.ID] = c
+	if c.ID > m.lastID {
+		m.lastID = c.ID
+	}
+	for _, v := range c.Versions {
+		if v.ID > m.lastVer {
+			m.lastVer = v.ID
+		}
+	}
+}
+
+func (m *MemoryStore) Effect(id int) (*Effect, error) {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	e, ok := m.effects[uint(id)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return copyEffect(e), nil
+}
+
+func (m *MemoryStore) Effects(page, size int) ([]Effect, error) {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	all := make([]*Effect, 0, len(m.effects))
+	for _, e := range m.effects {
+		all = append(all, e)
+	}
+
+	return pageEffects(all, page, size), nil
+}
+
+// pageEffects returns copies of a page of the effects sorted by modification
+// time, newest first.
+func pageEffects(all []*Effect, page, size int) []Effect {
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Modified.Equal(all[j].Modified) {
+			return all[i].ID > all[j].ID
+		}
+		return all[i].Modified.After(all[j].Modified)
+	})
+
+	start := page * size
+	if start >= len(all) || start < 0 {
+		return nil
+	}
+	end := start + size
+	if end > len(all) {
+		end = len(all)
+	}
+
+	effects := make([]Effect, 0, end-start)
+	for _, e := range all[start:end] {
+		effects = append(effects, *copyEffect(e))
+	}
+
+	return effects
+}
+
+func (m *MemoryStore) Children(ids ...uint) ([]Effect, error) {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	parents := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		parents[id] = true
+	}
+
+	var effects []Effect
+	for _, e := range m.effects {
+		if !parents[e.ParentID] {
+			continue
+		}
+
+		c := copyEffect(e)
+		for i := range c.Versions {
+			c.Versions[i].Code = ""
+		}
+		effects = append(effects, *c)
+	}
+
+	sort.Slice(effects, func(i, j int) bool {
+		return effects[i].ID < effects[j].ID
+	})
+
+	return effects, nil
+}
+
+func (m *MemoryStore) Search(query string, page, size int) ([]Effect, int, error) {
+	terms := queryTerms(query)
+	if len(terms) == 0 {
+		return nil, 0, nil
+	}
+
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	type result struct {
+		effect *Effect
+		score  int
+	}
+
+	var results []result
+	for _, e := range m.effects {
+		indexed := effectTerms(e)
+		score := 0
+		for _, t := range terms {
+			if indexed[t] == 0 {
+				score = 0
+				break
+			}
+			score += indexed[t]
+		}
+
+		if score > 0 {
+			results = append(results, result{effect: e, score: score})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score == results[j].score {
+			return results[i].effect.ID > results[j].effect.ID
+		}
+		return results[i].score > results[j].score
+	})
+
+	start := page * size
+	if start >= len(results) || start < 0 {
+		return nil, len(results), nil
+	}
+	end := start + size
+	if end > len(results) {
+		end = len(results)
+	}
+
+	effects := make([]Effect, 0, end-start)
+	for _, r := range results[start:end] {
+		effects = append(effects, *copyEffect(r.effect))
+	}
+
+	return effects, len(results), nil
+}
+
+func (m *MemoryStore) EffectCount() (int, error) {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	return len(m.effects), nil
+}
+
+func (m *MemoryStore) VersionCount() (int, error) {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	var count int
+	for _, e := range m.effects {
+		count += len(e.Versions)
+	}
+
+	return count, nil
+}
+
+func (m *MemoryStore) LastVersionTime(id int) (time.Time, error) {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	e, ok := m.effects[uint(id)]
+	if !ok || len(e.Versions) == 0 {
+		return time.Time{}, ErrNotFound
+	}
+
+	return e.Versions[len(e.Versions)-1].Created, nil
+}
+
+// Ping always succeeds as there is nothing to connect to.
+func (m *MemoryStore) Ping() error {
+	return nil
+}
+
+func (m *MemoryStore) UpdateTime(e *Effect) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	stored, ok := m.effects[e.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	now := time.Now()
+	stored.Modified = now
+	e.Modified = now
+
+	return nil
+}
+
+func (m *MemoryStore) NewEffect(
+	parent, version int,
+	user, owner string,
+) (*Effect, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.lastID++
+	effect := &Effect{
+		ID:            m.lastID,
+		Created:       time.Now(),
+		Modified:      time.Now(),
+		ParentID:      uint(parent),
+		ParentVersion: version,
+		User:          user,
+		Owner:         owner,
+	}
+	m.effects[effect.ID] = copyEffect(effect)
+
+	return effect, nil
+}
+
+func (m *MemoryStore) AddVersion(e *Effect, code string) (*Version, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	stored, ok := m.effects[e.ID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	m.lastVer++
+	version := Version{
+		ID:       m.lastVer,
+		EffectID: e.ID,
+		Number:   stored.NextVersion(),
+		Created:  time.Now(),
+		Code:     code,
+	}
+	stored.Versions = append(stored.Versions, version)
+	e.Versions = append(e.Versions, version)
+
+	return &version, nil
+}
+
+func (m *MemoryStore) NewAccount(a *Account) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	for _, stored := range m.accounts {
+		if stored.Name == a.Name || stored.Identity == a.Identity {
+			return ErrConflict
+		}
+	}
+
+	m.lastAccount++
+	a.ID = m.lastAccount
+	a.Created = time.Now()
+	m.accounts = append(m.accounts, *a)
+
+	return nil
+}
+
+func (m *MemoryStore) Account(name string) (*Account, error) {
+	return m.findAccount(func(a *Account) bool { return a.Name == name })
+}
+
+func (m *MemoryStore) AccountByIdentity(identity string) (*Account, error) {
+	return m.findAccount(func(a *Account) bool { return a.Identity == identity })
+}
+
+func (m *MemoryStore) AccountByLogin(provider, subject string) (*Account, error) {
+	m.m.RLock()
+	var id uint
+	for _, l := range m.logins {
+		if l.Provider == provider && l.Subject == subject {
+			id = l.AccountID
+		}
+	}
+	m.m.RUnlock()
+
+	if id == 0 {
+		return nil, ErrNotFound
+	}
+
+	return m.findAccount(func(a *Account) bool { return a.ID == id })
+}
+
+func (m *MemoryStore) findAccount(match func(*Account) bool) (*Account, error) {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	for _, a := range m.accounts {
+		if match(&a) {
+			return &a, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) AddLogin(account uint, provider, subject string) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	for _, l := range m.logins {
+		if l.Provider == provider && l.Subject == subject {
+			return ErrConflict
+		}
+	}
+
+	m.logins = append(m.logins, AccountLogin{
+		ID:        uint(len(m.logins) + 1),
+		AccountID: account,
+		Provider:  provider,
+		Subject:   subject,
+	})
+
+	return nil
+}
+
+func (m *MemoryStore) ClaimEffects(from, to string) (int, error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	var count int
+	for _, e := range m.effects {
+		if e.Owner == from {
+			e.Owner = to
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (m *MemoryStore) OwnerEffects(owner string, page, size int) ([]Effect, error) {
+	m.m.RLock()
+	defer m.m.RUnlock()
+
+	var all []*Effect
+	for _, e := range m.effects {
+		if e.Owner == owner {
+			all = append(all, e)
+		}
+	}
+
+	return pageEffects(all, page, size), nil
+}
+
+// WithLogger returns the same store as it does not log.
+func (m *MemoryStore) WithLogger(logger log.Logger) Store {
+	return m
+}
+
+func (m *MemoryStore) Transaction(fn func(Store) error) error {
+	m.tx.Lock()
+	defer m.tx.Unlock()
+
+	m.m.RLock()
+	effects := make(map[uint]*Effect, len(m.effects))
+	for id, e := range m.effects {
+		effects[id] = copyEffect(e)
+	}
+	lastID, lastVer := m.lastID, m.lastVer
+	accounts := append([]Account(nil), m.accounts...)
+	logins := append([]AccountLogin(nil), m.logins...)
+	lastAccount := m.lastAccount
+	m.m.RUnlock()
+
+	err := fn(m)
+	if err != nil {
+		m.m.Lock()
+		m.effects = effects
+		m.lastID, m.lastVer = lastID, lastVer
+		m.accounts, m.logins = accounts, logins
+		m.lastAccount = lastAccount
+		m.m.Unlock()
+	}
+
+	return err
+}
+
+func copyEffect(e *Effect) *Effect {
+	c := *e
+	c.Parent = nil
+	c.Versions = make([]Version, len(e.Versions))
+	copy(c.Versions, e.Versions)
+	return &c
+}
@@ -0,0 +1,226 @@
+package glsl
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+const metricsNamespace = "glsl"
+
+// Stages of a save used to label its failures.
+const (
+	saveStageRequest   = "request"
+	saveStageRateLimit = "rate_limit"
+	saveStageEffect    = "effect"
+	saveStageVersion   = "version"
+	saveStageImage     = "image"
+	saveStageCommit    = "commit"
+)
+
+// metrics holds the prometheus collectors of a Server. Each Server has its
+// own registry so several of them can live in the same process.
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests          *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	saves             *prometheus.CounterVec
+	queryDuration     *prometheus.HistogramVec
+	imageBytesWritten prometheus.Counter
+	imageBytesServed  prometheus.Counter
+}
+
+func newMetrics(db Store) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Number of http requests by route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of http requests by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		saves: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "saves_total",
+			Help:      "Number of saves by result and stage where they failed.",
+		}, []string{"result", "stage"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Latency of database queries.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"query"}),
+		imageBytesWritten: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "image_written_bytes_total",
+			Help:      "Bytes written to the image store.",
+		}),
+		imageBytesServed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "image_served_bytes_total",
+			Help:      "Bytes of images sent to clients.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.saves,
+		m.queryDuration,
+		m.imageBytesWritten,
+		m.imageBytesServed,
+		newStoreCollector(db),
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+// handler serves the metrics in prometheus text format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// middleware records the number and duration of the requests labeled with
+// the chi route pattern that handled them.
+func (m *metrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := routePattern(r)
+		m.requestDuration.WithLabelValues(r.Method, route).
+			Observe(time.Since(start).Seconds())
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.requests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+	})
+}
+
+// routePattern returns the pattern of the route that handled the request or
+// "unknown" if none matched, so urls do not end up in the labels.
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return "unknown"
+	}
+
+	pattern := rctx.RoutePattern()
+	if pattern == "" {
+		return "unknown"
+	}
+
+	return pattern
+}
+
+func (m *metrics) saveSucceeded() {
+	m.saves.WithLabelValues("success", "").Inc()
+}
+
+func (m *metrics) saveFailed(stage string) {
+	m.saves.WithLabelValues("failure", stage).Inc()
+}
+
+// meteredStore is a Store that records the duration of the gallery, item and
+// image queries.
+type meteredStore struct {
+	Store
+	m *metrics
+}
+
+func (s *meteredStore) Effect(id int) (*Effect, error) {
+	defer s.m.observeQuery("effect", time.Now())
+	return s.Store.Effect(id)
+}
+
+func (s *meteredStore) Effects(page, size int) ([]Effect, error) {
+	defer s.m.observeQuery("effects", time.Now())
+	return s.Store.Effects(page, size)
+}
+
+func (s *meteredStore) LastVersionTime(id int) (time.Time, error) {
+	defer s.m.observeQuery("last_version_time", time.Now())
+	return s.Store.LastVersionTime(id)
+}
+
+func (s *meteredStore) WithLogger(logger log.Logger) Store {
+	return &meteredStore{Store: s.Store.WithLogger(logger), m: s.m}
+}
+
+func (m *metrics) observeQuery(query string, start time.Time) {
+	m.queryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+}
+
+// meteredImages is an ImageStore that counts the bytes written, except the
+// ones of readiness checks.
+type meteredImages struct {
+	ImageStore
+	m *metrics
+}
+
+func (i *meteredImages) Put(name string, data []byte) error {
+	err := i.ImageStore.Put(name, data)
+	if err == nil && name != readyImage {
+		i.m.imageBytesWritten.Add(float64(len(data)))
+	}
+
+	return err
+}
+
+// storeCollector reports the number of effects and versions in the store
+// each time the metrics are collected.
+type storeCollector struct {
+	db       Store
+	effects  *prometheus.Desc
+	versions *prometheus.Desc
+}
+
+func newStoreCollector(db Store) *storeCollector {
+	return &storeCollector{
+		db: db,
+		effects: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "effects"),
+			"Number of stored effects.", nil, nil),
+		versions: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "versions"),
+			"Number of stored versions.", nil, nil),
+	}
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.effects
+	ch <- c.versions
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	count := func(desc *prometheus.Desc, fn func() (int, error)) {
+		n, err := fn()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(desc, err)
+			return
+		}
+
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue,
+			float64(n))
+	}
+
+	count(c.effects, c.db.EffectCount)
+	count(c.versions, c.db.VersionCount)
+}
@@ -0,0 +1,98 @@
+package glsl
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	images, err := NewFSImageStore(dir)
+	require.NoError(err)
+
+	store := &failingStore{Store: NewMemoryStore()}
+	server := NewServer(store, images, false)
+	h := server.router()
+
+	res := postSave(t, h, saveCode{Code: "code", Image: testImage, User: "u"})
+	require.Equal(http.StatusOK, res.Code)
+
+	res = postSave(t, h, saveCode{Code: "", Image: testImage, User: "u"})
+	require.Equal(http.StatusUnprocessableEntity, res.Code)
+
+	store.fail = "AddVersion"
+	res = postSave(t, h, saveCode{Code: "code", Image: testImage, User: "u"})
+	require.Equal(http.StatusInternalServerError, res.Code)
+
+	for _, path := range []string{
+		"/item/1",
+		"/images/1.png",
+		"/api/v1/effects/1",
+		"/not/found",
+	} {
+		res = httptest.NewRecorder()
+		h.ServeHTTP(res, httptest.NewRequest("GET", path, nil))
+	}
+
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(http.StatusOK, res.Code)
+	body := res.Body.String()
+
+	_, original, err := decodeImage(testImage)
+	require.NoError(err)
+	imageSize := len(original)
+
+	expected := []string{
+		`glsl_http_requests_total{method="POST",route="/e",status="200"} 1`,
+		`glsl_http_requests_total{method="POST",route="/e",status="422"} 1`,
+		`glsl_http_requests_total{method="POST",route="/e",status="500"} 1`,
+		`glsl_http_requests_total{method="GET",route="/item/{effect:[0-9]+}",status="200"} 1`,
+		`glsl_http_requests_total{method="GET",route="/api/v1/effects/{id:[0-9]+}",status="200"} 1`,
+		`glsl_http_requests_total{method="GET",route="unknown",status="404"} 1`,
+		`glsl_http_request_duration_seconds_count{method="POST",route="/e"} 3`,
+		`glsl_saves_total{result="success",stage=""} 1`,
+		`glsl_saves_total{result="failure",stage="request"} 1`,
+		`glsl_saves_total{result="failure",stage="version"} 1`,
+		`glsl_db_query_duration_seconds_count{query="effect"} 2`,
+		`glsl_db_query_duration_seconds_count{query="last_version_time"} 1`,
+		`glsl_image_served_bytes_total ` + strconv.Itoa(imageSize),
+		`glsl_effects 1`,
+		`glsl_versions 1`,
+	}
+
+	for _, e := range expected {
+		require.Contains(body, e)
+	}
+
+	// the original and the thumbnails are written
+	written := metricValue(t, body, "glsl_image_written_bytes_total")
+	require.True(written > float64(imageSize), "written %v", written)
+}
+
+func metricValue(t *testing.T, body, name string) float64 {
+	t.Helper()
+
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, name+" ") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(line, name+" "), 64)
+			require.NoError(t, err)
+			return v
+		}
+	}
+
+	require.FailNow(t, "metric not found", name)
+	return 0
+}
@@ -0,0 +1,383 @@
+package glsl
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// ErrSchemaOutdated is returned by CheckSchema when the database schema does
+// not match the one expected by this version of the code.
+var ErrSchemaOutdated = errors.New("database schema is outdated")
+
+// migration changes the schema from version-1 to version with up and
+// reverts it with down. Migrations must not use the model types as they
+// change with the code. Use types frozen at the time of the migration.
+type migration struct {
+	version int
+	name    string
+	up      func(db *gorm.DB) error
+	down    func(db *gorm.DB) error
+}
+
+// migrations holds all the schema changes sorted by version. Version numbers
+// must be consecutive starting from 1.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "create effects and versions",
+		up: func(db *gorm.DB) error {
+			// databases created before migrations were added already have
+			// these tables, AutoMigrate leaves them as they are
+			err := db.AutoMigrate(&effectV1{}, &versionV1{}).Error
+			if err != nil {
+				return err
+			}
+
+			// mysql text columns are limited to 64KiB
+			if db.Dialect().GetName() == MySQL {
+				return db.Model(&versionV1{}).
+					ModifyColumn("code", "mediumtext").Error
+			}
+
+			return nil
+		},
+		down: func(db *gorm.DB) error {
+			return db.DropTableIfExists(&versionV1{}, &effectV1{}).Error
+		},
+	},
+	{
+		version: 2,
+		name:    "index effect parents",
+		up: func(db *gorm.DB) error {
+			return db.Model(&effectV1{}).
+				AddIndex("parent_id", "parent_id").Error
+		},
+		down: func(db *gorm.DB) error {
+			return db.Model(&effectV1{}).RemoveIndex("parent_id").Error
+		},
+	},
+	{
+		// the index of existing effects is filled with the reindex command
+		version: 3,
+		name:    "create search index",
+		up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&searchTermV3{}).Error
+		},
+		down: func(db *gorm.DB) error {
+			return db.DropTableIfExists(&searchTermV3{}).Error
+		},
+	},
+	{
+		// it fails if there are duplicated versions, they must be fixed by
+		// hand before migrating
+		version: 4,
+		name:    "unique version numbers",
+		up: func(db *gorm.DB) error {
+			return db.Model(&versionV1{}).AddUniqueIndex(
+				"effect_version", "effect_id", "number").Error
+		},
+		down: func(db *gorm.DB) error {
+			return db.Model(&versionV1{}).RemoveIndex("effect_version").Error
+		},
+	},
+	{
+		// existing effects have no owner so they can only be forked
+		version: 5,
+		name:    "effect owners",
+		up: func(db *gorm.DB) error {
+			err := db.AutoMigrate(&effectV5{}).Error
+			if err != nil {
+				return err
+			}
+
+			return db.Model(&effectV5{}).AddIndex("owner", "owner").Error
+		},
+		down: func(db *gorm.DB) error {
+			err := db.Model(&effectV5{}).RemoveIndex("owner").Error
+			if err != nil {
+				return err
+			}
+
+			// sqlite cannot drop columns, the empty one is left
+			if db.Dialect().GetName() == SQLite {
+				return nil
+			}
+
+			return db.Model(&effectV5{}).DropColumn("owner").Error
+		},
+	},
+	{
+		version: 6,
+		name:    "create accounts",
+		up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&accountV6{}, &accountLoginV6{}).Error
+		},
+		down: func(db *gorm.DB) error {
+			return db.DropTableIfExists(&accountLoginV6{}, &accountV6{}).Error
+		},
+	},
+	{
+		version: 7,
+		name:    "effect image urls",
+		up: func(db *gorm.DB) error {
+			return db.AutoMigrate(&effectV7{}).Error
+		},
+		down: func(db *gorm.DB) error {
+			// sqlite cannot drop columns, the empty one is left
+			if db.Dialect().GetName() == SQLite {
+				return nil
+			}
+
+			return db.Model(&effectV7{}).DropColumn("image_url").Error
+		},
+	},
+	{
+		version: 8,
+		name:    "version sources",
+		up: func(db *gorm.DB) error {
+			err := db.AutoMigrate(&versionV8{}).Error
+			if err != nil {
+				return err
+			}
+
+			return db.Model(&versionV8{}).
+				AddIndex("version_source", "source").Error
+		},
+		down: func(db *gorm.DB) error {
+			err := db.Model(&versionV8{}).RemoveIndex("version_source").Error
+			if err != nil {
+				return err
+			}
+
+			// sqlite cannot drop columns, the empty one is left
+			if db.Dialect().GetName() == SQLite {
+				return nil
+			}
+
+			return db.Model(&versionV8{}).DropColumn("source").Error
+		},
+	},
+}
+
+type effectV1 struct {
+	ID            uint `gorm:"primary_key"`
+	Created       time.Time
+	Modified      time.Time `gorm:"index:modified"`
+	ParentID      uint
+	ParentVersion int
+	User          string
+}
+
+func (effectV1) TableName() string { return "effects" }
+
+type effectV5 struct {
+	ID            uint `gorm:"primary_key"`
+	Created       time.Time
+	Modified      time.Time `gorm:"index:modified"`
+	ParentID      uint
+	ParentVersion int
+	User          string
+	Owner         string `gorm:"size:64"`
+}
+
+func (effectV5) TableName() string { return "effects" }
+
+type effectV7 struct {
+	ID            uint `gorm:"primary_key"`
+	Created       time.Time
+	Modified      time.Time `gorm:"index:modified"`
+	ParentID      uint
+	ParentVersion int
+	User          string
+	Owner         string `gorm:"size:64"`
+	ImageURL      string `gorm:"size:255"`
+}
+
+func (effectV7) TableName() string { return "effects" }
+
+type accountV6 struct {
+	ID       uint `gorm:"primary_key"`
+	Created  time.Time
+	Name     string `gorm:"size:32;unique_index:account_name"`
+	Identity string `gorm:"size:64;unique_index:account_identity"`
+	Password string `gorm:"size:60"`
+}
+
+func (accountV6) TableName() string { return "accounts" }
+
+type accountLoginV6 struct {
+	ID        uint   `gorm:"primary_key"`
+	AccountID uint   `gorm:"index:login_account_id"`
+	Provider  string `gorm:"size:32;unique_index:login_subject"`
+	Subject   string `gorm:"size:255;unique_index:login_subject"`
+}
+
+func (accountLoginV6) TableName() string { return "account_logins" }
+
+type versionV1 struct {
+	ID       uint
+	EffectID uint `gorm:"index:effect_id"`
+	Number   int
+	Created  time.Time
+	Code     string `gorm:"type:text"`
+}
+
+func (versionV1) TableName() string { return "versions" }
+
+type versionV8 struct {
+	ID       uint
+	EffectID uint `gorm:"index:effect_id"`
+	Number   int
+	Created  time.Time
+	Code     string `gorm:"type:text"`
+	Source   string `gorm:"size:255"`
+}
+
+func (versionV8) TableName() string { return "versions" }
+
+type searchTermV3 struct {
+	Term     string `gorm:"primary_key;size:64"`
+	EffectID uint   `gorm:"primary_key;auto_increment:false;index:search_effect_id"`
+	Count    int
+}
+
+func (searchTermV3) TableName() string { return "search_terms" }
+
+// schemaVersion records each applied migration.
+type schemaVersion struct {
+	Version int `gorm:"primary_key;auto_increment:false"`
+	Name    string
+	Applied time.Time
+}
+
+func (schemaVersion) TableName() string { return "schema_version" }
+
+// LatestSchema returns the schema version expected by the code.
+func LatestSchema() int {
+	return len(migrations)
+}
+
+// SchemaVersion returns the schema version of the database. Databases without
+// schema_version table have version 0.
+func (d *Database) SchemaVersion() (int, error) {
+	if !d.HasTable(&schemaVersion{}) {
+		return 0, nil
+	}
+
+	var versions []schemaVersion
+	err := d.Order("version desc").Limit(1).Find(&versions).Error
+	if err != nil {
+		d.log().Errorf(err, "cannot retrieve schema version")
+		return 0, err
+	}
+
+	if len(versions) == 0 {
+		return 0, nil
+	}
+
+	return versions[0].Version, nil
+}
+
+// CheckSchema returns ErrSchemaOutdated if the database is not on the latest
+// schema version.
+func (d *Database) CheckSchema() error {
+	version, err := d.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if version != LatestSchema() {
+		d.log().With(log.Fields{
+			"version":  version,
+			"expected": LatestSchema(),
+		}).Errorf(ErrSchemaOutdated, "run migrate to update the database")
+		return ErrSchemaOutdated
+	}
+
+	return nil
+}
+
+// Migrate applies or reverts migrations until the database is on the target
+// schema version. Each migration is run in its own transaction.
+func (d *Database) Migrate(target int) error {
+	if target < 0 || target > LatestSchema() {
+		return fmt.Errorf("invalid schema version %v", target)
+	}
+
+	err := d.AutoMigrate(&schemaVersion{}).Error
+	if err != nil {
+		d.log().Errorf(err, "cannot create schema_version table")
+		return err
+	}
+
+	current, err := d.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for current < target {
+		m := migrations[current]
+		err = d.runMigration(m, m.up, func(tx *gorm.DB) error {
+			return tx.Create(&schemaVersion{
+				Version: m.version,
+				Name:    m.name,
+				Applied: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return err
+		}
+
+		d.log().Infof("applied migration %v: %v", m.version, m.name)
+		current++
+	}
+
+	for current > target {
+		m := migrations[current-1]
+		err = d.runMigration(m, m.down, func(tx *gorm.DB) error {
+			return tx.Delete(&schemaVersion{Version: m.version}).Error
+		})
+		if err != nil {
+			return err
+		}
+
+		d.log().Infof("reverted migration %v: %v", m.version, m.name)
+		current--
+	}
+
+	return nil
+}
+
+func (d *Database) runMigration(
+	m migration,
+	change func(*gorm.DB) error,
+	record func(*gorm.DB) error,
+) error {
+	tx := d.Begin()
+	if tx.Error != nil {
+		d.log().Errorf(tx.Error, "cannot start transaction")
+		return tx.Error
+	}
+
+	err := change(tx)
+	if err == nil {
+		err = record(tx)
+	}
+	if err != nil {
+		tx.Rollback()
+		d.log().Errorf(err, "migration %v failed", m.version)
+		return err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		d.log().Errorf(err, "cannot commit migration %v", m.version)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package glsl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testDatabase(t testing.TB) *Database {
+	t.Helper()
+
+	db, err := OpenDatabase(SQLite, ":memory:")
+	require.NoError(t, err)
+
+	return db
+}
+
+func TestMigrate(t *testing.T) {
+	require := require.New(t)
+
+	db := testDatabase(t)
+	defer db.Close()
+
+	version, err := db.SchemaVersion()
+	require.NoError(err)
+	require.Equal(0, version)
+	require.Equal(ErrSchemaOutdated, db.CheckSchema())
+
+	err = db.Migrate(LatestSchema())
+	require.NoError(err)
+
+	version, err = db.SchemaVersion()
+	require.NoError(err)
+	require.Equal(LatestSchema(), version)
+	require.NoError(db.CheckSchema())
+	require.True(db.HasTable("effects"))
+	require.True(db.HasTable("versions"))
+
+	// migrating again does nothing
+	err = db.Migrate(LatestSchema())
+	require.NoError(err)
+
+	err = db.Migrate(0)
+	require.NoError(err)
+
+	version, err = db.SchemaVersion()
+	require.NoError(err)
+	require.Equal(0, version)
+	require.False(db.HasTable("effects"))
+	require.False(db.HasTable("versions"))
+
+	require.Error(db.Migrate(LatestSchema() + 1))
+	require.Error(db.Migrate(-1))
+}
+
+func TestMigrateExistingDatabase(t *testing.T) {
+	require := require.New(t)
+
+	db := testDatabase(t)
+	defer db.Close()
+
+	// schema created before migrations existed
+	err := db.AutoMigrate(&Effect{}, &Version{}).Error
+	require.NoError(err)
+	err = db.Create(&Effect{
+		ID:       1,
+		Created:  time.Now(),
+		Modified: time.Now(),
+		Versions: []Version{{Code: "code"}},
+	}).Error
+	require.NoError(err)
+
+	require.Equal(ErrSchemaOutdated, db.CheckSchema())
+
+	err = db.Migrate(LatestSchema())
+	require.NoError(err)
+	require.NoError(db.CheckSchema())
+
+	e, err := db.Effect(1)
+	require.NoError(err)
+	require.Len(e.Versions, 1)
+	require.Equal("code", e.Versions[0].Code)
+}
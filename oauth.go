@@ -0,0 +1,205 @@
+package glsl
+
+import (
+	"context"
+	"crypto/hmac"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	// oauthCookie keeps the state of a login with an OAuth provider until
+	// the user comes back to the callback.
+	oauthCookie = "glsl_oauth"
+	// oauthMaxAge is the time given to the user to log in with the
+	// provider.
+	oauthMaxAge = 10 * time.Minute
+	// maxNameAttempts is the number of names tried when the one given by
+	// the provider is already used.
+	maxNameAttempts = 10
+)
+
+// OAuthProvider authenticates users with an external service using the
+// OAuth 2 authorization code flow.
+type OAuthProvider interface {
+	// Name identifies the provider in the login urls and the stored
+	// accounts. It must not change.
+	Name() string
+	// AuthURL returns the address of the provider where the user is sent to
+	// log in. The provider redirects back to redirectURL with the state and
+	// a code.
+	AuthURL(state, redirectURL string) string
+	// User exchanges the code received in the callback and returns the user
+	// that logged in.
+	User(ctx context.Context, code, redirectURL string) (*OAuthUser, error)
+}
+
+// OAuthUser is a user of an OAuth provider.
+type OAuthUser struct {
+	// ID is the identifier of the user in the provider. It must not change.
+	ID string
+	// Name is used as the account name if it is not already used.
+	Name string
+}
+
+// WithOAuthProvider lets users log in with the provider. It can be used
+// several times with different providers.
+func WithOAuthProvider(p OAuthProvider) ServerOption {
+	return func(s *Server) {
+		if s.oauthProviders == nil {
+			s.oauthProviders = make(map[string]OAuthProvider)
+		}
+		s.oauthProviders[p.Name()] = p
+	}
+}
+
+// oauthRedirectURL returns the callback address of the provider.
+func (s *Server) oauthRedirectURL(r *http.Request, provider string) string {
+	scheme := "http"
+	if s.secureRequest(r) {
+		scheme = "https"
+	}
+
+	return fmt.Sprintf("%v://%v/login/%v/callback", scheme, r.Host, provider)
+}
+
+// oauthLogin sends the user to the provider to log in.
+func (s *Server) oauthLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.oauthProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+
+	state, err := newIdentity()
+	if err != nil {
+		requestLogger(r).Errorf(err, "cannot create oauth state")
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	claim := "0"
+	if r.URL.Query().Get("claim") != "" {
+		claim = "1"
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthCookie,
+		Value:    signSession(s.sessionSecret, state+"-"+claim, time.Now()),
+		Path:     "/login/",
+		MaxAge:   int(oauthMaxAge.Seconds()),
+		Secure:   s.secureRequest(r),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	redirect := s.oauthRedirectURL(r, provider.Name())
+	http.Redirect(w, r, provider.AuthURL(state, redirect), http.StatusFound)
+}
+
+// oauthCallback logs in the user coming back from the provider. A new
+// account is created the first time.
+func (s *Server) oauthCallback(w http.ResponseWriter, r *http.Request) {
+	db := s.store(r)
+	logger := requestLogger(r)
+
+	provider, ok := s.oauthProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   oauthCookie,
+		Path:   "/login/",
+		MaxAge: -1,
+	})
+
+	var value string
+	if c, err := r.Cookie(oauthCookie); err == nil {
+		value, _, ok = verifySession(s.sessionSecret, c.Value, oauthMaxAge)
+	}
+
+	i := strings.LastIndex(value, "-")
+	state := r.URL.Query().Get("state")
+	if !ok || i < 0 || !hmac.Equal([]byte(value[:i]), []byte(state)) {
+		s.renderLogin(w, r, http.StatusBadRequest, LoginPage{
+			Error: "login expired, try again",
+		})
+		return
+	}
+	claim := value[i+1:] == "1"
+
+	redirect := s.oauthRedirectURL(r, provider.Name())
+	user, err := provider.User(r.Context(), r.URL.Query().Get("code"), redirect)
+	if err != nil {
+		logger.Errorf(err, "cannot get %v user", provider.Name())
+		s.renderLogin(w, r, http.StatusUnauthorized, LoginPage{
+			Error: fmt.Sprintf("could not log in with %v", provider.Name()),
+		})
+		return
+	}
+
+	account, err := oauthAccount(db, provider.Name(), user)
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	s.logIn(w, r, account, claim)
+}
+
+// oauthAccount returns the account linked to the provider user, creating
+// it if needed. The user name is used for the new account, adding a number
+// when it is already used.
+func oauthAccount(db Store, provider string, user *OAuthUser) (*Account, error) {
+	name := user.Name
+	if name == "" {
+		name = user.ID
+	}
+	name = accountName(name)
+
+	for i := 1; i <= maxNameAttempts; i++ {
+		account, err := db.AccountByLogin(provider, user.ID)
+		if err != ErrNotFound {
+			return account, err
+		}
+
+		candidate := name
+		if i > 1 {
+			suffix := fmt.Sprintf("-%d", i)
+			if len(candidate)+len(suffix) > maxAccountName {
+				candidate = candidate[:maxAccountName-len(suffix)]
+			}
+			candidate += suffix
+		}
+
+		account, err = newAccount(candidate, "")
+		if err != nil {
+			return nil, err
+		}
+
+		err = db.Transaction(func(tx Store) error {
+			err := tx.NewAccount(account)
+			if err != nil {
+				return err
+			}
+
+			return tx.AddLogin(account.ID, provider, user.ID)
+		})
+		if err == ErrConflict {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return account, nil
+	}
+
+	return nil, fmt.Errorf("cannot find a free account name for %v", name)
+}
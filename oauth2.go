@@ -0,0 +1,207 @@
+package glsl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxOAuthResponse is the maximum size of the responses of the provider.
+const maxOAuthResponse = 1 << 20
+
+// OAuth2Config configures an OAuth2Provider.
+type OAuth2Config struct {
+	// Name identifies the provider, see OAuthProvider.
+	Name         string
+	ClientID     string
+	ClientSecret string
+	// AuthURL is the address where users log in and TokenURL the one where
+	// the codes are exchanged for access tokens.
+	AuthURL  string
+	TokenURL string
+	// UserURL returns the user that logged in as a JSON object when it is
+	// requested with the access token.
+	UserURL string
+	Scopes  []string
+	// IDField and NameField are the fields of the user object with its
+	// identifier and name.
+	IDField   string
+	NameField string
+	// Client is used to make the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// OAuth2Provider is an OAuthProvider for services implementing the
+// standard authorization code flow.
+type OAuth2Provider struct {
+	config OAuth2Config
+}
+
+var _ OAuthProvider = new(OAuth2Provider)
+
+// NewOAuth2Provider creates an OAuth2Provider with the given configuration.
+func NewOAuth2Provider(config OAuth2Config) *OAuth2Provider {
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+
+	return &OAuth2Provider{config: config}
+}
+
+// NewGitHubProvider creates a provider for the GitHub OAuth app with the
+// given credentials. The account names are the GitHub logins.
+func NewGitHubProvider(clientID, clientSecret string) *OAuth2Provider {
+	return NewOAuth2Provider(OAuth2Config{
+		Name:         "github",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      "https://github.com/login/oauth/authorize",
+		TokenURL:     "https://github.com/login/oauth/access_token",
+		UserURL:      "https://api.github.com/user",
+		IDField:      "id",
+		NameField:    "login",
+	})
+}
+
+func (p *OAuth2Provider) Name() string {
+	return p.config.Name
+}
+
+func (p *OAuth2Provider) AuthURL(state, redirectURL string) string {
+	values := url.Values{
+		"client_id":     {p.config.ClientID},
+		"redirect_uri":  {redirectURL},
+		"response_type": {"code"},
+		"state":         {state},
+	}
+	if len(p.config.Scopes) > 0 {
+		values.Set("scope", strings.Join(p.config.Scopes, " "))
+	}
+
+	sep := "?"
+	if strings.Contains(p.config.AuthURL, "?") {
+		sep = "&"
+	}
+
+	return p.config.AuthURL + sep + values.Encode()
+}
+
+func (p *OAuth2Provider) User(
+	ctx context.Context,
+	code, redirectURL string,
+) (*OAuthUser, error) {
+	token, err := p.token(ctx, code, redirectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", p.config.UserURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	var user map[string]interface{}
+	err = p.do(ctx, req, &user)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get %v user: %v", p.config.Name, err)
+	}
+
+	id := jsonString(user[p.config.IDField])
+	if id == "" {
+		return nil, fmt.Errorf("%v user has no %v", p.config.Name,
+			p.config.IDField)
+	}
+
+	return &OAuthUser{
+		ID:   id,
+		Name: jsonString(user[p.config.NameField]),
+	}, nil
+}
+
+// token exchanges the code for an access token.
+func (p *OAuth2Provider) token(
+	ctx context.Context,
+	code, redirectURL string,
+) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {p.config.ClientID},
+		"client_secret": {p.config.ClientSecret},
+	}
+
+	req, err := http.NewRequest("POST", p.config.TokenURL,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var res struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = p.do(ctx, req, &res)
+	if err == nil && res.Error != "" {
+		err = fmt.Errorf("%v: %v", res.Error, res.ErrorDescription)
+	}
+	if err == nil && res.AccessToken == "" {
+		err = fmt.Errorf("no access token")
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot get %v token: %v", p.config.Name, err)
+	}
+
+	return res.AccessToken, nil
+}
+
+// do sends the request and decodes the JSON response in v.
+func (p *OAuth2Provider) do(
+	ctx context.Context,
+	req *http.Request,
+	v interface{},
+) error {
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.config.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxOAuthResponse))
+	if err != nil {
+		return err
+	}
+
+	// errors of the token endpoint come with status 400 and a JSON body
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %v", res.StatusCode)
+	}
+
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// jsonString returns a string or number decoded from JSON as a string.
+func jsonString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return ""
+	}
+}
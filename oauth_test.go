@@ -0,0 +1,216 @@
+package glsl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProvider accepts the codes of its users map.
+type fakeProvider struct {
+	users map[string]*OAuthUser
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) AuthURL(state, redirectURL string) string {
+	return "https://fake.test/auth?" + url.Values{
+		"state":        {state},
+		"redirect_uri": {redirectURL},
+	}.Encode()
+}
+
+func (p *fakeProvider) User(
+	ctx context.Context,
+	code, redirectURL string,
+) (*OAuthUser, error) {
+	if redirectURL != "http://example.com/login/fake/callback" {
+		return nil, errors.New("invalid redirect url")
+	}
+
+	user, ok := p.users[code]
+	if !ok {
+		return nil, errors.New("invalid code")
+	}
+
+	return user, nil
+}
+
+func TestOAuth(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+
+	provider := &fakeProvider{users: map[string]*OAuthUser{
+		"code-1": {ID: "1", Name: "Alice"},
+		"code-2": {ID: "2", Name: "alice"},
+		"code-3": {ID: "3"},
+	}}
+	WithOAuthProvider(provider)(server)
+	h := server.router()
+
+	res := postSave(t, h, saveCode{Code: "0", Image: testImage, User: "anon"})
+	require.Equal("1.0", res.Body.String())
+
+	res = getAs(h, "/login", "id-anon")
+	require.Contains(res.Body.String(), "/login/fake?claim=1")
+
+	// start logs in with the provider and returns the state cookie
+	start := func(claim bool) (*http.Cookie, string) {
+		path := "/login/fake"
+		if claim {
+			path += "?claim=1"
+		}
+
+		res := getAs(h, path, "id-anon")
+		require.Equal(http.StatusFound, res.Code)
+
+		u, err := url.Parse(res.Header().Get("Location"))
+		require.NoError(err)
+		require.Equal("fake.test", u.Host)
+		require.Equal("http://example.com/login/fake/callback",
+			u.Query().Get("redirect_uri"))
+
+		c := responseCookies(res)[oauthCookie]
+		require.NotNil(c)
+		require.True(c.HttpOnly)
+
+		return c, u.Query().Get("state")
+	}
+
+	callback := func(c *http.Cookie, state, code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/login/fake/callback?"+url.Values{
+			"state": {state},
+			"code":  {code},
+		}.Encode(), nil)
+		req.AddCookie(&http.Cookie{
+			Name:  sessionCookie,
+			Value: signSession(testSecret, "id-anon", time.Now()),
+		})
+		if c != nil {
+			req.AddCookie(c)
+		}
+
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+
+	// the state must match the cookie
+	c, state := start(true)
+	res = callback(nil, state, "code-1")
+	require.Equal(http.StatusBadRequest, res.Code)
+	res = callback(c, "other", "code-1")
+	require.Equal(http.StatusBadRequest, res.Code)
+	res = callback(c, state, "invalid")
+	require.Equal(http.StatusUnauthorized, res.Code)
+
+	// the first login creates the account
+	res = callback(c, state, "code-1")
+	require.Equal(http.StatusSeeOther, res.Code)
+	require.Equal("/u/alice", res.Header().Get("Location"))
+
+	alice, err := store.AccountByLogin("fake", "1")
+	require.NoError(err)
+	require.Equal("alice", alice.Name)
+	require.Empty(alice.Password)
+	require.Equal(alice.Identity, responseCookies(res)[identityCookie].Value)
+
+	e, err := store.Effect(1)
+	require.NoError(err)
+	require.Equal(alice.Identity, e.Owner)
+
+	// accounts without password cannot log in with one
+	res = postForm(h, "/login", "id-new", url.Values{
+		"name": {"alice"}, "password": {""},
+	})
+	require.Equal(http.StatusUnauthorized, res.Code)
+
+	// next logins use the same account
+	c, state = start(false)
+	res = callback(c, state, "code-1")
+	require.Equal(http.StatusSeeOther, res.Code)
+	require.Equal(alice.Identity, responseCookies(res)[identityCookie].Value)
+
+	// used names get a number
+	c, state = start(false)
+	res = callback(c, state, "code-2")
+	require.Equal("/u/alice-2", res.Header().Get("Location"))
+
+	c, state = start(false)
+	res = callback(c, state, "code-3")
+	require.Equal("/u/3__", res.Header().Get("Location"))
+
+	res = getAs(h, "/login/unknown", "id-anon")
+	require.Equal(http.StatusNotFound, res.Code)
+}
+
+func TestOAuth2Provider(t *testing.T) {
+	require := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(r.ParseForm())
+		require.Equal("client", r.PostForm.Get("client_id"))
+		require.Equal("secret", r.PostForm.Get("client_secret"))
+		require.Equal("http://example.com/callback", r.PostForm.Get("redirect_uri"))
+
+		if r.PostForm.Get("code") != "code" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "bad_verification_code"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token": "token", "token_type": "bearer"}`))
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"id": 12345678901, "login": "alice"}`))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p := NewOAuth2Provider(OAuth2Config{
+		Name:         "test",
+		ClientID:     "client",
+		ClientSecret: "secret",
+		AuthURL:      server.URL + "/auth?prompt=login",
+		TokenURL:     server.URL + "/token",
+		UserURL:      server.URL + "/user",
+		Scopes:       []string{"read", "email"},
+		IDField:      "id",
+		NameField:    "login",
+	})
+
+	auth, err := url.Parse(p.AuthURL("state", "http://example.com/callback"))
+	require.NoError(err)
+	require.Equal("/auth", auth.Path)
+	require.Equal(url.Values{
+		"prompt":        {"login"},
+		"client_id":     {"client"},
+		"redirect_uri":  {"http://example.com/callback"},
+		"response_type": {"code"},
+		"scope":         {"read email"},
+		"state":         {"state"},
+	}, auth.Query())
+
+	user, err := p.User(context.Background(), "code", "http://example.com/callback")
+	require.NoError(err)
+	require.Equal(&OAuthUser{ID: "12345678901", Name: "alice"}, user)
+
+	_, err = p.User(context.Background(), "invalid", "http://example.com/callback")
+	require.Error(err)
+	require.Contains(err.Error(), "bad_verification_code")
+
+	require.Equal("github", NewGitHubProvider("id", "secret").Name())
+}
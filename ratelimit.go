@@ -0,0 +1,277 @@
+package glsl
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits how often a client can do an action. The in memory
+// implementation is MemoryRateLimiter, others can share the limits between
+// several servers.
+type RateLimiter interface {
+	// Allow consumes a token of the client identified by key. If there are
+	// none left it returns false and the time until the next one is
+	// available.
+	Allow(key string) (bool, time.Duration)
+}
+
+// RateLimit configures a token bucket. Rate is the number of tokens added
+// per second and Burst the maximum number of tokens in the bucket.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// bucketCleanupInterval is how often full buckets are removed from memory.
+const bucketCleanupInterval = time.Minute
+
+// MemoryRateLimiter is a RateLimiter that keeps a token bucket per key in
+// memory.
+type MemoryRateLimiter struct {
+	limit RateLimit
+	now   func() time.Time
+
+	m           sync.Mutex
+	buckets     map[string]*bucket
+	lastCleanup time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewMemoryRateLimiter creates a MemoryRateLimiter with the given limit. The
+// burst must be at least 1 or no action would ever be allowed.
+func NewMemoryRateLimiter(limit RateLimit) (*MemoryRateLimiter, error) {
+	if limit.Burst < 1 {
+		return nil, fmt.Errorf("rate limit burst must be at least 1, got %v",
+			limit.Burst)
+	}
+
+	return &MemoryRateLimiter{
+		limit:   limit,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}, nil
+}
+
+func (l *MemoryRateLimiter) Allow(key string) (bool, time.Duration) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	now := l.now()
+	l.cleanup(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = l.refill(b, now)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	if l.limit.Rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+
+	wait := (1 - b.tokens) / l.limit.Rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// refill returns the tokens in the bucket after adding the ones generated
+// since it was last used.
+func (l *MemoryRateLimiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.limit.Rate
+	return math.Min(tokens, float64(l.limit.Burst))
+}
+
+// cleanup removes the buckets that are full as they are the same as new
+// ones.
+func (l *MemoryRateLimiter) cleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < bucketCleanupInterval {
+		return
+	}
+	l.lastCleanup = now
+
+	for k, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.limit.Burst) {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// WithRateLimits limits the saves done by each client. The versions limiter
+// is used for every save, as all of them add a version, and is checked
+// before reading the request. The effects limiter is also used for saves
+// that create a new effect, including forks. Any of them can be nil to
+// disable the limit.
+func WithRateLimits(effects, versions RateLimiter) ServerOption {
+	return func(s *Server) {
+		s.effectLimiter = effects
+		s.versionLimiter = versions
+	}
+}
+
+// WithTrustedProxies makes the server get the client address from the
+// X-Forwarded-For or X-Real-IP headers when the request comes from one of
+// these networks.
+func WithTrustedProxies(nets []*net.IPNet) ServerOption {
+	return func(s *Server) {
+		s.trustedProxies = nets
+	}
+}
+
+// ParseNetworks parses a list of CIDR networks or single IP addresses.
+func ParseNetworks(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, n := range list {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+
+		if !strings.Contains(n, "/") {
+			ip := net.ParseIP(n)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", n)
+			}
+
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			n = fmt.Sprintf("%v/%v", n, bits)
+		}
+
+		_, ipNet, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return nets, nil
+}
+
+// clientIP returns the address of the client. Proxy headers are only used
+// when the connection comes from a trusted proxy. X-Forwarded-For is read
+// from the right skipping the trusted proxies, as the left part can be set
+// to anything by the client.
+func (s *Server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !s.trustedProxy(host) {
+		return host
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		addrs := strings.Split(forwarded, ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(addrs[i])
+			if net.ParseIP(addr) == nil {
+				break
+			}
+
+			host = addr
+			if !s.trustedProxy(addr) {
+				break
+			}
+		}
+
+		return host
+	}
+
+	if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(real) != nil {
+		return real
+	}
+
+	return host
+}
+
+func (s *Server) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range s.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkSaveRate consumes a token of the versions limiter. It is called
+// before reading the body so clients over the limit cannot make the server
+// read large requests. It returns an error with status 429 when the client
+// has to wait.
+func (s *Server) checkSaveRate(r *http.Request) error {
+	return s.allow(r, s.versionLimiter)
+}
+
+// checkEffectRate consumes a token of the effects limiter if saving the data
+// creates a new effect.
+func (s *Server) checkEffectRate(r *http.Request, db Store, data saveCode) error {
+	if s.effectLimiter == nil || !newEffect(db, data) {
+		return nil
+	}
+
+	return s.allow(r, s.effectLimiter)
+}
+
+// allow consumes a token of the limiter for the client of the request. It
+// returns an error with status 429 when the client has to wait.
+func (s *Server) allow(r *http.Request, limiter RateLimiter) error {
+	if limiter == nil {
+		return nil
+	}
+
+	ok, wait := limiter.Allow(s.clientIP(r))
+	if ok {
+		return nil
+	}
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	return &requestError{
+		Status:     http.StatusTooManyRequests,
+		Message:    fmt.Sprintf("too many saves, retry in %v seconds", seconds),
+		RetryAfter: seconds,
+	}
+}
+
+// newEffect tells if saving the data creates a new effect. Errors loading
+// the effect are ignored, they are handled by the save itself.
+func newEffect(db Store, data saveCode) bool {
+	if data.CodeID == "" {
+		return true
+	}
+
+	id, _ := splitIDVersion(data.CodeID)
+	effect, err := db.Effect(id)
+	if err != nil {
+		return true
+	}
+
+	return !ownsEffect(effect, data)
+}
@@ -0,0 +1,182 @@
+package glsl
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRateLimiter(t *testing.T) {
+	require := require.New(t)
+
+	_, err := NewMemoryRateLimiter(RateLimit{Rate: 1, Burst: 0})
+	require.Error(err)
+
+	now := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	l, err := NewMemoryRateLimiter(RateLimit{Rate: 1, Burst: 2})
+	require.NoError(err)
+	l.now = func() time.Time { return now }
+
+	ok, _ := l.Allow("a")
+	require.True(ok)
+	ok, _ = l.Allow("a")
+	require.True(ok)
+	ok, wait := l.Allow("a")
+	require.False(ok)
+	require.Equal(time.Second, wait)
+
+	// other keys have their own bucket
+	ok, _ = l.Allow("b")
+	require.True(ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, wait = l.Allow("a")
+	require.False(ok)
+	require.Equal(500*time.Millisecond, wait)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	require.True(ok)
+	ok, _ = l.Allow("a")
+	require.False(ok)
+
+	// the bucket never has more than burst tokens
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		ok, _ = l.Allow("a")
+		require.True(ok)
+	}
+	ok, _ = l.Allow("a")
+	require.False(ok)
+
+	// full buckets are forgotten
+	now = now.Add(time.Hour)
+	l.Allow("c")
+	require.Len(l.buckets, 1)
+}
+
+func TestClientIP(t *testing.T) {
+	nets, err := ParseNetworks([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	require.NoError(t, err)
+	require.Len(t, nets, 3)
+
+	_, err = ParseNetworks([]string{"invalid"})
+	require.Error(t, err)
+
+	s := &Server{trustedProxies: nets}
+
+	tests := []struct {
+		remote   string
+		forward  string
+		real     string
+		expected string
+	}{
+		{"1.2.3.4:1000", "", "", "1.2.3.4"},
+		// headers from untrusted clients are ignored
+		{"1.2.3.4:1000", "5.5.5.5", "6.6.6.6", "1.2.3.4"},
+		{"10.0.0.1:1000", "5.5.5.5", "", "5.5.5.5"},
+		{"192.168.1.1:1000", "5.5.5.5", "", "5.5.5.5"},
+		{"192.168.1.2:1000", "5.5.5.5", "", "192.168.1.2"},
+		{"[::1]:1000", "5.5.5.5", "", "5.5.5.5"},
+		// trusted proxies are skipped from the right
+		{"10.0.0.1:1000", "5.5.5.5, 10.0.0.2", "", "5.5.5.5"},
+		// the client can put anything at the left
+		{"10.0.0.1:1000", "7.7.7.7, 5.5.5.5", "", "5.5.5.5"},
+		{"10.0.0.1:1000", "garbage, 5.5.5.5", "", "5.5.5.5"},
+		{"10.0.0.1:1000", "10.0.0.3, 10.0.0.2", "", "10.0.0.3"},
+		{"10.0.0.1:1000", "", "6.6.6.6", "6.6.6.6"},
+		{"10.0.0.1:1000", "", "garbage", "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/e", nil)
+		req.RemoteAddr = test.remote
+		if test.forward != "" {
+			req.Header.Set("X-Forwarded-For", test.forward)
+		}
+		if test.real != "" {
+			req.Header.Set("X-Real-IP", test.real)
+		}
+
+		require.Equal(t, test.expected, s.clientIP(req), "%+v", test)
+	}
+}
+
+func TestSaveRateLimit(t *testing.T) {
+	require := require.New(t)
+
+	server, _, cleanup := testServer(t)
+	defer cleanup()
+
+	effects, err := NewMemoryRateLimiter(RateLimit{Rate: 0.1, Burst: 1})
+	require.NoError(err)
+	versions, err := NewMemoryRateLimiter(RateLimit{Rate: 0.1, Burst: 4})
+	require.NoError(err)
+	WithRateLimits(effects, versions)(server)
+	h := server.router()
+
+	post := func(path, ip string, data saveCode) *httptest.ResponseRecorder {
+		body, err := json.Marshal(data)
+		require.NoError(err)
+
+		req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		req.RemoteAddr = ip + ":1234"
+		req.AddCookie(testSession(data.User))
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		return res
+	}
+
+	limited := func(res *httptest.ResponseRecorder) {
+		require.Equal(http.StatusTooManyRequests, res.Code)
+		require.Equal("10", res.Header().Get("Retry-After"))
+
+		var body apiError
+		require.NoError(json.Unmarshal(res.Body.Bytes(), &body))
+		require.Equal(http.StatusTooManyRequests, body.Error.Status)
+		require.Contains(body.Error.Message, "retry in 10 seconds")
+	}
+
+	res := post("/e", "1.1.1.1", saveCode{Code: "0", Image: testImage, User: "a"})
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal("1.0", res.Body.String())
+
+	limited(post("/e", "1.1.1.1", saveCode{Code: "0", Image: testImage, User: "a"}))
+	limited(post(apiPrefix+"/effects", "1.1.1.1",
+		saveCode{Code: "0", Image: testImage, User: "a"}))
+
+	// new versions have their own limit, also used by the saves of new
+	// effects
+	res = post("/e", "1.1.1.1", saveCode{
+		CodeID: "1.0", Code: "v", Image: testImage, User: "a",
+	})
+	require.Equal(http.StatusOK, res.Code)
+	limited(post("/e", "1.1.1.1", saveCode{
+		CodeID: "1.0", Code: "v", Image: testImage, User: "a",
+	}))
+
+	// the limit is checked before reading the body
+	req := httptest.NewRequest("POST", "/e", strings.NewReader("invalid"))
+	req.RemoteAddr = "1.1.1.1:1234"
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	limited(res)
+
+	// other clients are not affected
+	res = post("/e", "2.2.2.2", saveCode{
+		CodeID: "1.0", Code: "fork", Image: testImage, User: "b",
+	})
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal("2.0", res.Body.String())
+
+	// forks are new effects
+	limited(post("/e", "2.2.2.2", saveCode{
+		CodeID: "1.0", Code: "fork", Image: testImage, User: "b",
+	}))
+}
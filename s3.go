@@ -0,0 +1,164 @@
+package glsl
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3Options configures an S3ImageStore.
+type S3Options struct {
+	// Bucket where the images are saved.
+	Bucket string
+	// Prefix is prepended to the image names to build the object keys.
+	Prefix string
+	// Endpoint is the address of S3 compatible services like MinIO. It uses
+	// AWS when empty.
+	Endpoint string
+	Region   string
+	// AccessKey and SecretKey are the credentials. If empty the default AWS
+	// credential chain is used.
+	AccessKey string
+	SecretKey string
+	// PathStyle puts the bucket in the path instead of the host name. Most
+	// S3 compatible services need it.
+	PathStyle bool
+	// URLExpiration is the validity of the presigned URLs returned by URL.
+	// When zero URL returns an empty string.
+	URLExpiration time.Duration
+}
+
+// S3ImageStore is an ImageStore that saves images in an S3 compatible
+// service.
+type S3ImageStore struct {
+	client *s3.S3
+	opts   S3Options
+}
+
+var _ ImageStore = new(S3ImageStore)
+
+// NewS3ImageStore creates an S3ImageStore. The bucket must already exist.
+func NewS3ImageStore(opts S3Options) (*S3ImageStore, error) {
+	config := aws.NewConfig().
+		WithRegion(opts.Region).
+		WithS3ForcePathStyle(opts.PathStyle)
+
+	if opts.Endpoint != "" {
+		config = config.WithEndpoint(opts.Endpoint)
+	}
+
+	if opts.AccessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(
+			opts.AccessKey, opts.SecretKey, ""))
+	}
+
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3ImageStore{
+		client: s3.New(sess),
+		opts:   opts,
+	}, nil
+}
+
+func (s *S3ImageStore) key(name string) *string {
+	return aws.String(path.Join(s.opts.Prefix, path.Base(name)))
+}
+
+func (s *S3ImageStore) Put(name string, data []byte) error {
+	_, err := s.client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s.opts.Bucket),
+		Key:         s.key(name),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("image/png"),
+	})
+
+	return err
+}
+
+func (s *S3ImageStore) Get(name string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    s.key(name),
+	})
+	if isS3NotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Body, nil
+}
+
+func (s *S3ImageStore) Exists(name string) (bool, error) {
+	_, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    s.key(name),
+	})
+	if isS3NotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Rename copies the object to the new key and deletes the old one as S3 does
+// not support renames.
+func (s *S3ImageStore) Rename(from, to string) error {
+	source := path.Join(s.opts.Bucket, *s.key(from))
+	_, err := s.client.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(s.opts.Bucket),
+		Key:        s.key(to),
+		CopySource: aws.String(url.PathEscape(source)),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Delete(from)
+}
+
+func (s *S3ImageStore) Delete(name string) error {
+	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    s.key(name),
+	})
+
+	return err
+}
+
+func (s *S3ImageStore) URL(name string) (string, error) {
+	if s.opts.URLExpiration == 0 {
+		return "", nil
+	}
+
+	req, _ := s.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.opts.Bucket),
+		Key:    s.key(name),
+	})
+
+	return req.Presign(s.opts.URLExpiration)
+}
+
+func isS3NotFound(err error) bool {
+	if e, ok := err.(awserr.RequestFailure); ok {
+		return e.StatusCode() == http.StatusNotFound
+	}
+
+	return false
+}
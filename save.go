@@ -1,18 +1,15 @@
 package glsl
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
-	"time"
 
-	"github.com/jinzhu/gorm"
 	"gopkg.in/src-d/go-log.v1"
 )
 
@@ -23,52 +20,283 @@ type saveCode struct {
 	User          string `json:"user"`
 	Parent        string `json:"parent,omiempty"`
 	ParentVersion string `json:"parent_version,omiempty"`
+	// Owner is the identity of the client, it is not read from the body.
+	Owner string `json:"-"`
 }
 
-func (s *Server) save(w http.ResponseWriter, r *http.Request) {
+const (
+	// maxSaveSize is the maximum size of a save request body.
+	maxSaveSize = 8 << 20
+	// maxCodeSize is the maximum size of the code of a version.
+	maxCodeSize = 128 << 10
+	// maxUserSize is the size of the user column.
+	maxUserSize = 255
+	// maxImageWidth and maxImageHeight limit the size of the images.
+	maxImageWidth  = 2048
+	maxImageHeight = 2048
+)
+
+var idVersionRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// requestError is an error caused by an invalid request. It is sent to the
+// client with its status code. RetryAfter, if set, is the number of seconds
+// the client has to wait before trying again.
+type requestError struct {
+	Status     int
+	Field      string
+	Message    string
+	RetryAfter int
+}
+
+func (e *requestError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+
+	return fmt.Sprintf("%v: %v", e.Field, e.Message)
+}
+
+func invalidField(field, format string, args ...interface{}) *requestError {
+	return &requestError{
+		Status:  http.StatusUnprocessableEntity,
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// writeRequestError sends the error as json if it is a requestError or a
+// generic internal error otherwise.
+func writeRequestError(w http.ResponseWriter, err error) {
+	e, ok := err.(*requestError)
+	if !ok {
+		apiInternalError(w)
+		return
+	}
+
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(e.RetryAfter))
+	}
+
+	writeJSON(w, e.Status, apiError{Error: apiErrorBody{
+		Status:  e.Status,
+		Field:   e.Field,
+		Message: e.Message,
+	}})
+}
+
+// readSaveCode reads and validates the save request body.
+func readSaveCode(r *http.Request) (saveCode, error) {
+	logger := requestLogger(r)
+
 	data := saveCode{}
-	buffer, err := ioutil.ReadAll(r.Body)
+	tooLarge := &requestError{
+		Status:  http.StatusRequestEntityTooLarge,
+		Message: fmt.Sprintf("request larger than %v bytes", maxSaveSize),
+	}
+
+	if r.ContentLength > maxSaveSize {
+		return data, tooLarge
+	}
+
+	buffer, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSaveSize+1))
 	if err != nil {
-		log.Errorf(err, "cannot read body")
-		http.Error(w, http.StatusText(500), 500)
-		return
+		logger.Errorf(err, "cannot read body")
+		return data, &requestError{
+			Status:  http.StatusBadRequest,
+			Message: "cannot read body",
+		}
+	}
+
+	if len(buffer) > maxSaveSize {
+		return data, tooLarge
 	}
 
 	err = json.Unmarshal(buffer, &data)
 	if err != nil {
-		log.Errorf(err, "cannot unmarshal json")
-		http.Error(w, http.StatusText(500), 500)
-		return
+		return data, &requestError{
+			Status:  http.StatusBadRequest,
+			Message: fmt.Sprintf("invalid json: %v", err),
+		}
+	}
+
+	data.Owner = requestIdentity(r)
+	return data, data.validate()
+}
+
+func (d saveCode) validate() error {
+	if strings.TrimSpace(d.Code) == "" {
+		return invalidField("code", "code is empty")
+	}
+
+	if len(d.Code) > maxCodeSize {
+		return invalidField("code", "code larger than %v bytes", maxCodeSize)
+	}
+
+	if len(d.User) > maxUserSize {
+		return invalidField("user", "user longer than %v bytes", maxUserSize)
+	}
+
+	if d.CodeID != "" && !idVersionRegexp.MatchString(d.CodeID) {
+		return invalidField("code_id", "code_id must have id.version format")
+	}
+
+	if d.Parent != "" && !idVersionRegexp.MatchString(d.Parent) {
+		return invalidField("parent", "parent must have id.version format")
+	}
+
+	if d.Image == "" {
+		return invalidField("image", "image is empty")
 	}
 
-	effect, err := createOrUpdateEffect(s.db, data)
+	width, height, err := imageDimensions(d.Image)
 	if err != nil {
-		http.Error(w, http.StatusText(500), 500)
-		return
+		return invalidField("image", "image is not a valid png")
 	}
 
-	version := &Version{
-		EffectID: effect.ID,
-		Number:   effect.NextVersion(),
-		Created:  time.Now(),
-		Code:     data.Code,
+	if width < 1 || height < 1 ||
+		width > maxImageWidth || height > maxImageHeight {
+		return invalidField("image", "image must be at most %vx%v pixels",
+			maxImageWidth, maxImageHeight)
 	}
-	err = s.db.Create(version).Error
+
+	return nil
+}
+
+func (s *Server) save(w http.ResponseWriter, r *http.Request) {
+	effect, version, err := s.saveRequest(r)
 	if err != nil {
-		log.Errorf(err, "could not create version %v", effect.ID)
-		http.Error(w, http.StatusText(500), 500)
+		writeRequestError(w, err)
 		return
 	}
 
-	err = saveImage(effect.ID, data)
+	fmt.Fprintf(w, "%v.%v", effect.ID, version.Number)
+}
+
+// saveRequest checks the rate limits and saves the code sent in the request.
+// It is shared by the editor and API handlers, that only format the
+// response.
+func (s *Server) saveRequest(r *http.Request) (*Effect, *Version, error) {
+	err := s.checkSaveRate(r)
 	if err != nil {
-		http.Error(w, http.StatusText(500), 500)
-		return
+		s.metrics.saveFailed(saveStageRateLimit)
+		return nil, nil, err
 	}
 
-	log.Debugf("saved effect %v", effect.ID)
+	data, err := readSaveCode(r)
+	if err != nil {
+		s.metrics.saveFailed(saveStageRequest)
+		return nil, nil, err
+	}
 
-	fmt.Fprintf(w, "%v.%v", effect.ID, version.Number)
+	err = s.checkEffectRate(r, s.store(r), data)
+	if err != nil {
+		s.metrics.saveFailed(saveStageRateLimit)
+		return nil, nil, err
+	}
+
+	return s.saveEffect(requestLogger(r), data)
+}
+
+// maxSaveAttempts is the number of times a save is tried when it conflicts
+// with other one saving the same effect.
+const maxSaveAttempts = 5
+
+// saveEffect stores the code sent by the editor as a new version and its
+// image. A new effect is created if it does not exist or the user is not its
+// owner. The images are saved with temporary names before the database
+// changes are done in a transaction and renamed as its last step, so the
+// version and its images are saved together or not at all. The transaction
+// is retried if other version was saved concurrently. The errors are logged
+// with the given logger.
+func (s *Server) saveEffect(
+	logger log.Logger,
+	data saveCode,
+) (*Effect, *Version, error) {
+	var (
+		effect  *Effect
+		version *Version
+		stage   string
+		err     error
+	)
+
+	staged, err := stageImage(logger, s.images, data)
+	if err != nil {
+		stage = saveStageImage
+	} else {
+		for i := 0; i < maxSaveAttempts; i++ {
+			effect, version, stage, err = s.trySaveEffect(logger, staged, data)
+			if err != ErrConflict {
+				break
+			}
+
+			logger.Debugf("version conflict saving %v, retrying", data.CodeID)
+		}
+	}
+
+	if err != nil {
+		if staged != nil {
+			staged.discard()
+		}
+		s.metrics.saveFailed(stage)
+	}
+	if err == ErrInvalidImage {
+		return nil, nil, invalidField("image", "image is not a valid png")
+	}
+	if err != nil {
+		logger.Errorf(err, "could not save effect")
+		return nil, nil, err
+	}
+
+	staged.done()
+	s.metrics.saveSucceeded()
+	logger.Debugf("saved effect %v", effect.ID)
+
+	return effect, version, nil
+}
+
+// trySaveEffect does a single attempt saving the effect and version in the
+// database and committing the staged images. The images are rolled back if
+// the transaction fails. On error it also returns the stage where it failed.
+func (s *Server) trySaveEffect(
+	logger log.Logger,
+	staged *stagedImages,
+	data saveCode,
+) (*Effect, *Version, string, error) {
+	var (
+		effect  *Effect
+		version *Version
+		stage   string
+	)
+
+	err := s.db.WithLogger(logger).Transaction(func(db Store) error {
+		var err error
+		stage = saveStageEffect
+		effect, err = createOrUpdateEffect(logger, db, data)
+		if err != nil {
+			return err
+		}
+
+		stage = saveStageVersion
+		version, err = db.AddVersion(effect, data.Code)
+		if err != nil {
+			return err
+		}
+
+		stage = saveStageImage
+		err = staged.commit(effect.ID)
+		if err != nil {
+			return err
+		}
+
+		stage = saveStageCommit
+		return nil
+	})
+	if err != nil {
+		staged.rollback()
+		return nil, nil, stage, err
+	}
+
+	return effect, version, "", nil
 }
 
 func splitIDVersion(s string) (int, int) {
@@ -90,7 +318,18 @@ func splitIDVersion(s string) (int, int) {
 	return 0, 0
 }
 
-func createOrUpdateEffect(db *Database, data saveCode) (*Effect, error) {
+// ownsEffect tells if the client saving the data is the owner of the
+// effect. The user name is not used as anyone can send it.
+func ownsEffect(effect *Effect, data saveCode) bool {
+	return effect.Owner != "" && effect.Owner == data.Owner
+}
+
+// createOrUpdateEffect returns the effect where the new version is saved.
+func createOrUpdateEffect(
+	logger log.Logger,
+	db Store,
+	data saveCode,
+) (*Effect, error) {
 	parent, parentVersion := splitIDVersion(data.Parent)
 
 	var (
@@ -103,18 +342,18 @@ func createOrUpdateEffect(db *Database, data saveCode) (*Effect, error) {
 		codeID, codeVersion = splitIDVersion(data.CodeID)
 
 		effect, err = db.Effect(codeID)
-		if err != nil && !gorm.IsRecordNotFoundError(err) {
-			log.Errorf(err, "could not retrieve code %v", codeID)
+		if err != nil && err != ErrNotFound {
+			logger.Errorf(err, "could not retrieve code %v", codeID)
 			return nil, err
 		}
 	}
 
 	// check if the owner is saving
 	if effect != nil {
-		if effect.User == data.User {
+		if ownsEffect(effect, data) {
 			err = db.UpdateTime(effect)
 			if err != nil {
-				log.Errorf(err, "could not update code %v", codeID)
+				logger.Errorf(err, "could not update code %v", codeID)
 				return nil, err
 			}
 		} else {
@@ -124,44 +363,16 @@ func createOrUpdateEffect(db *Database, data saveCode) (*Effect, error) {
 		}
 	}
 
-	// create new record for new effects
-	if effect == nil {
-		effect, err = db.NewEffect(parent, parentVersion, data.User)
-		if err != nil {
-			log.Errorf(err, "could not create code %v", codeID)
-			return nil, err
-		}
-	}
-
-	return effect, nil
-}
-func saveImage(id uint, data saveCode) error {
-	imageName := fmt.Sprintf("%v.png", id)
-	imagePath := filepath.Join("images", imageName)
-	f, err := os.Create(imagePath)
-	if err != nil {
-		log.Errorf(err, "could not create image %v", id)
-		return err
-	}
-	defer f.Close()
-
-	base := strings.Split(data.Image, ",")
-	if len(base) == 0 {
-		log.Errorf(err, "invalid image %v", id)
-		return err
-	}
-
-	decoded, err := base64.StdEncoding.DecodeString(base[len(base)-1])
-	if err != nil {
-		log.Errorf(err, "invalid image %v", id)
-		return err
+	if effect != nil {
+		return effect, nil
 	}
 
-	_, err = f.Write(decoded)
+	// create new record for new effects
+	effect, err = db.NewEffect(parent, parentVersion, data.User, data.Owner)
 	if err != nil {
-		log.Errorf(err, "could not save image %v", id)
-		return err
+		logger.Errorf(err, "could not create code %v", codeID)
+		return nil, err
 	}
 
-	return nil
+	return effect, nil
 }
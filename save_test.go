@@ -0,0 +1,666 @@
+package glsl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+func TestSaveValidation(t *testing.T) {
+	valid := saveCode{
+		Code:  "void main(void) {}",
+		Image: testImage,
+		User:  "user",
+	}
+
+	tests := []struct {
+		name   string
+		modify func(d *saveCode)
+		status int
+		field  string
+	}{
+		{
+			name:   "valid",
+			modify: func(d *saveCode) {},
+			status: http.StatusOK,
+		},
+		{
+			name:   "valid with ids",
+			modify: func(d *saveCode) { d.CodeID = "10.2"; d.Parent = "3" },
+			status: http.StatusOK,
+		},
+		{
+			name:   "empty code",
+			modify: func(d *saveCode) { d.Code = " \n\t" },
+			status: http.StatusUnprocessableEntity,
+			field:  "code",
+		},
+		{
+			name:   "large code",
+			modify: func(d *saveCode) { d.Code = strings.Repeat("a", maxCodeSize+1) },
+			status: http.StatusUnprocessableEntity,
+			field:  "code",
+		},
+		{
+			name:   "long user",
+			modify: func(d *saveCode) { d.User = strings.Repeat("a", maxUserSize+1) },
+			status: http.StatusUnprocessableEntity,
+			field:  "user",
+		},
+		{
+			name:   "invalid code_id",
+			modify: func(d *saveCode) { d.CodeID = "1.a" },
+			status: http.StatusUnprocessableEntity,
+			field:  "code_id",
+		},
+		{
+			name:   "invalid parent",
+			modify: func(d *saveCode) { d.Parent = "-1" },
+			status: http.StatusUnprocessableEntity,
+			field:  "parent",
+		},
+		{
+			name:   "empty image",
+			modify: func(d *saveCode) { d.Image = "" },
+			status: http.StatusUnprocessableEntity,
+			field:  "image",
+		},
+		{
+			name:   "invalid image",
+			modify: func(d *saveCode) { d.Image = "data:image/png;base64,bm90IGEgcG5n" },
+			status: http.StatusUnprocessableEntity,
+			field:  "image",
+		},
+		{
+			name: "large image",
+			modify: func(d *saveCode) {
+				d.Image = pngImage(t, maxImageWidth+1, 1)
+			},
+			status: http.StatusUnprocessableEntity,
+			field:  "image",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			server, _, cleanup := testServer(t)
+			defer cleanup()
+
+			data := valid
+			test.modify(&data)
+
+			res := postSave(t, server.router(), data)
+			require.Equal(test.status, res.Code)
+			if test.status == http.StatusOK {
+				return
+			}
+
+			var apiErr apiError
+			err := json.Unmarshal(res.Body.Bytes(), &apiErr)
+			require.NoError(err)
+			require.Equal(test.status, apiErr.Error.Status)
+			require.Equal(test.field, apiErr.Error.Field)
+			require.NotEmpty(apiErr.Error.Message)
+		})
+	}
+}
+
+func TestSaveInvalidBody(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	req := httptest.NewRequest("POST", "/e", strings.NewReader("{"))
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	require.Equal(http.StatusBadRequest, res.Code)
+
+	large := bytes.Repeat([]byte(" "), maxSaveSize+1)
+	req = httptest.NewRequest("POST", "/e", bytes.NewReader(large))
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	require.Equal(http.StatusRequestEntityTooLarge, res.Code)
+
+	// without content length the body is still limited
+	req = httptest.NewRequest("POST", "/e", bytes.NewReader(large))
+	req.ContentLength = -1
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	require.Equal(http.StatusRequestEntityTooLarge, res.Code)
+
+	count, err := store.EffectCount()
+	require.NoError(err)
+	require.Equal(0, count)
+}
+
+var errFailure = errors.New("injected failure")
+
+// failingStore is a Store that fails in the operation named by fail.
+type failingStore struct {
+	Store
+	fail string
+}
+
+func (f *failingStore) NewEffect(
+	parent, version int,
+	user, owner string,
+) (*Effect, error) {
+	if f.fail == "NewEffect" {
+		return nil, errFailure
+	}
+	return f.Store.NewEffect(parent, version, user, owner)
+}
+
+func (f *failingStore) UpdateTime(e *Effect) error {
+	if f.fail == "UpdateTime" {
+		return errFailure
+	}
+	return f.Store.UpdateTime(e)
+}
+
+func (f *failingStore) AddVersion(e *Effect, code string) (*Version, error) {
+	if f.fail == "AddVersion" {
+		return nil, errFailure
+	}
+	return f.Store.AddVersion(e, code)
+}
+
+func (f *failingStore) WithLogger(logger log.Logger) Store {
+	return &failingStore{Store: f.Store.WithLogger(logger), fail: f.fail}
+}
+
+func (f *failingStore) Transaction(fn func(Store) error) error {
+	return f.Store.Transaction(func(tx Store) error {
+		err := fn(&failingStore{Store: tx, fail: f.fail})
+		if err == nil && f.fail == "Commit" {
+			return errFailure
+		}
+		return err
+	})
+}
+
+// failingImages is an ImageStore that fails once in the operation named by
+// fail after it succeeded the given number of times.
+type failingImages struct {
+	ImageStore
+	fail  string
+	after int
+}
+
+func (f *failingImages) check(op string) error {
+	if f.fail != op {
+		return nil
+	}
+	if f.after > 0 {
+		f.after--
+		return nil
+	}
+	f.fail = ""
+	return errFailure
+}
+
+func (f *failingImages) Put(name string, data []byte) error {
+	if err := f.check("Put"); err != nil {
+		return err
+	}
+	return f.ImageStore.Put(name, data)
+}
+
+func (f *failingImages) Rename(from, to string) error {
+	if err := f.check("Rename"); err != nil {
+		return err
+	}
+	return f.ImageStore.Rename(from, to)
+}
+
+func TestSaveAtomicMemory(t *testing.T) {
+	testSaveAtomic(t, func(t *testing.T) (Store, func()) {
+		return NewMemoryStore(), func() {}
+	})
+}
+
+func TestSaveAtomicSQLite(t *testing.T) {
+	testSaveAtomic(t, func(t *testing.T) (Store, func()) {
+		db := testDatabase(t)
+		require.NoError(t, db.Migrate(LatestSchema()))
+		return db, func() { db.Close() }
+	})
+}
+
+func testSaveAtomic(t *testing.T, newStore func(*testing.T) (Store, func())) {
+	tests := []struct {
+		name       string
+		storeFail  string
+		imageFail  string
+		imageAfter int
+		// only runs the test with this save
+		only string
+	}{
+		{name: "new effect", storeFail: "NewEffect", only: "fork"},
+		{name: "update time", storeFail: "UpdateTime", only: "owner"},
+		{name: "add version", storeFail: "AddVersion"},
+		{name: "first image", imageFail: "Put"},
+		{name: "thumbnail", imageFail: "Put", imageAfter: 2},
+		{name: "first rename", imageFail: "Rename"},
+		{name: "last rename", imageFail: "Rename", imageAfter: len(imageSizes)},
+		// the previous images are also renamed when they are replaced
+		{
+			name:       "last replace",
+			imageFail:  "Rename",
+			imageAfter: 2*len(imageSizes) + 1,
+			only:       "owner",
+		},
+		{name: "commit", storeFail: "Commit"},
+	}
+
+	// the owner adds a version to effect 1 and other user forks it, both
+	// with a different image
+	image := pngImage(t, 400, 200)
+	saves := []struct {
+		name string
+		id   uint
+		data saveCode
+	}{
+		{
+			name: "owner",
+			id:   1,
+			data: saveCode{CodeID: "1.0", Code: "new", Image: image, User: "owner"},
+		},
+		{
+			name: "fork",
+			id:   2,
+			data: saveCode{CodeID: "1.0", Code: "fork", Image: image, User: "other"},
+		},
+	}
+
+	for _, test := range tests {
+		for _, save := range saves {
+			if test.only != "" && test.only != save.name {
+				continue
+			}
+
+			t.Run(test.name+"/"+save.name, func(t *testing.T) {
+				require := require.New(t)
+
+				dir, err := ioutil.TempDir("", "glsl")
+				require.NoError(err)
+				defer os.RemoveAll(dir)
+
+				images, err := NewFSImageStore(dir)
+				require.NoError(err)
+
+				store, cleanup := newStore(t)
+				defer cleanup()
+
+				server := NewServer(store, images, false,
+					WithSessionSecret(testSecret))
+				res := postSave(t, server.router(), saveCode{
+					Code:  "original",
+					Image: testImage,
+					User:  "owner",
+				})
+				require.Equal(http.StatusOK, res.Code)
+
+				before := readDir(t, dir)
+
+				server = NewServer(
+					&failingStore{Store: store, fail: test.storeFail},
+					&failingImages{
+						ImageStore: images,
+						fail:       test.imageFail,
+						after:      test.imageAfter,
+					},
+					false,
+					WithSessionSecret(testSecret),
+				)
+				res = postSave(t, server.router(), save.data)
+				require.Equal(http.StatusInternalServerError, res.Code)
+
+				count, err := store.EffectCount()
+				require.NoError(err)
+				require.Equal(1, count)
+
+				e, err := store.Effect(1)
+				require.NoError(err)
+				require.Len(e.Versions, 1)
+				require.Equal("original", e.Versions[0].Code)
+
+				// the images of the effect are the previous ones
+				require.Equal(before, readDir(t, dir))
+
+				// the store is still usable and saves all the new images
+				server = NewServer(store, images, false,
+					WithSessionSecret(testSecret))
+				res = postSave(t, server.router(), save.data)
+				require.Equal(http.StatusOK, res.Code)
+
+				_, original, err := decodeImage(image)
+				require.NoError(err)
+				files := readDir(t, dir)
+				require.Equal(original, files[imageName(save.id, "")])
+				for _, size := range imageSizes {
+					require.Contains(files, imageName(save.id, size.name))
+				}
+				// no temporary images are left
+				require.Len(files, int(save.id)*len(before))
+			})
+		}
+	}
+}
+
+// readDir returns the content of the files in dir by name.
+func readDir(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+
+	files := make(map[string][]byte)
+	for _, name := range listDir(t, dir) {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		files[name] = data
+	}
+
+	return files
+}
+
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+
+	return names
+}
+
+func TestSaveConcurrentMemory(t *testing.T) {
+	testSaveConcurrent(t, NewMemoryStore())
+}
+
+func TestSaveConcurrentSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDatabase(SQLite, filepath.Join(dir, "effects.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Migrate(LatestSchema()))
+
+	testSaveConcurrent(t, db)
+}
+
+// conflictingStore is a Store where the given number of versions conflict
+// with others saved concurrently. The conflicting versions are added before
+// ErrConflict is returned so the transaction has to be rolled back.
+type conflictingStore struct {
+	Store
+	conflicts *int
+	attempts  *int
+}
+
+func (c *conflictingStore) AddVersion(e *Effect, code string) (*Version, error) {
+	*c.attempts++
+
+	version, err := c.Store.AddVersion(e, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if *c.conflicts > 0 {
+		*c.conflicts--
+		return nil, ErrConflict
+	}
+
+	return version, nil
+}
+
+func (c *conflictingStore) WithLogger(logger log.Logger) Store {
+	return &conflictingStore{
+		Store:     c.Store.WithLogger(logger),
+		conflicts: c.conflicts,
+		attempts:  c.attempts,
+	}
+}
+
+func (c *conflictingStore) Transaction(fn func(Store) error) error {
+	return c.Store.Transaction(func(tx Store) error {
+		return fn(&conflictingStore{
+			Store:     tx,
+			conflicts: c.conflicts,
+			attempts:  c.attempts,
+		})
+	})
+}
+
+func TestSaveConflictMemory(t *testing.T) {
+	testSaveConflict(t, NewMemoryStore())
+}
+
+func TestSaveConflictSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := OpenDatabase(SQLite, filepath.Join(dir, "effects.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Migrate(LatestSchema()))
+
+	testSaveConflict(t, db)
+}
+
+func testSaveConflict(t *testing.T, store Store) {
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	images, err := NewFSImageStore(dir)
+	require.NoError(t, err)
+
+	var conflicts, attempts int
+	server := NewServer(&conflictingStore{
+		Store:     store,
+		conflicts: &conflicts,
+		attempts:  &attempts,
+	}, images, false, WithSessionSecret(testSecret))
+	h := server.router()
+
+	tests := []struct {
+		name      string
+		data      saveCode
+		conflicts int
+		status    int
+		id        string
+	}{
+		{
+			name:      "new effect",
+			data:      saveCode{Code: "0", Image: testImage, User: "owner"},
+			conflicts: 1,
+			status:    http.StatusOK,
+			id:        "1.0",
+		},
+		{
+			name: "new version",
+			data: saveCode{
+				CodeID: "1.0", Code: "1", Image: testImage, User: "owner",
+			},
+			conflicts: 2,
+			status:    http.StatusOK,
+			id:        "1.1",
+		},
+		{
+			name: "too many conflicts",
+			data: saveCode{
+				CodeID: "1.1", Code: "2", Image: testImage, User: "owner",
+			},
+			conflicts: maxSaveAttempts,
+			status:    http.StatusInternalServerError,
+		},
+		{
+			name: "after failure",
+			data: saveCode{
+				CodeID: "1.1", Code: "3", Image: testImage, User: "owner",
+			},
+			status: http.StatusOK,
+			id:     "1.2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require := require.New(t)
+
+			conflicts, attempts = test.conflicts, 0
+
+			res := postSave(t, h, test.data)
+			require.Equal(test.status, res.Code)
+			if test.status == http.StatusOK {
+				require.Equal(test.id, res.Body.String())
+				require.Equal(test.conflicts+1, attempts)
+			} else {
+				require.Equal(maxSaveAttempts, attempts)
+			}
+		})
+	}
+
+	require := require.New(t)
+
+	count, err := store.EffectCount()
+	require.NoError(err)
+	require.Equal(1, count)
+
+	e, err := store.Effect(1)
+	require.NoError(err)
+
+	var codes []string
+	for i, v := range e.Versions {
+		require.Equal(i, v.Number)
+		codes = append(codes, v.Code)
+	}
+	require.Equal([]string{"0", "1", "3"}, codes)
+
+	files := listDir(t, dir)
+	require.Len(files, len(imageSizes)+1)
+}
+
+func testSaveConcurrent(t *testing.T, store Store) {
+	require := require.New(t)
+
+	const saves = 30
+
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	images, err := NewFSImageStore(dir)
+	require.NoError(err)
+
+	server := httptest.NewServer(NewServer(store, images, false,
+		WithSessionSecret(testSecret)).router())
+	defer server.Close()
+
+	post := func(data saveCode) (string, error) {
+		body, err := json.Marshal(data)
+		if err != nil {
+			return "", err
+		}
+
+		req, err := http.NewRequest("POST", server.URL+"/e",
+			bytes.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		req.AddCookie(testSession(data.User))
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+
+		text, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return "", err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("status %v: %s", res.StatusCode, text)
+		}
+
+		return string(text), nil
+	}
+
+	id, err := post(saveCode{Code: "0", Image: testImage, User: "owner"})
+	require.NoError(err)
+	require.Equal("1.0", id)
+
+	var wg sync.WaitGroup
+	results := make(chan string, saves)
+	errs := make(chan error, saves)
+	for i := 0; i < saves; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// all the saves come from an editor with version 0 loaded
+			id, err := post(saveCode{
+				CodeID: "1.0",
+				Code:   strconv.Itoa(i + 1),
+				Image:  testImage,
+				User:   "owner",
+			})
+			if err != nil {
+				errs <- err
+				return
+			}
+			results <- id
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+	close(errs)
+
+	for err := range errs {
+		require.NoError(err)
+	}
+
+	seen := make(map[string]bool)
+	for id := range results {
+		require.False(seen[id], "duplicated version %v", id)
+		seen[id] = true
+	}
+	require.Len(seen, saves)
+
+	e, err := store.Effect(1)
+	require.NoError(err)
+	require.Len(e.Versions, saves+1)
+
+	codes := make(map[string]bool)
+	for i, v := range e.Versions {
+		require.Equal(i, v.Number)
+		require.True(seen[fmt.Sprintf("1.%v", i)] || i == 0)
+		codes[v.Code] = true
+	}
+	require.Len(codes, saves+1)
+}
@@ -0,0 +1,221 @@
+package glsl
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// maxTermLength is the size of the term column of the search index.
+	// Longer words are not indexed.
+	maxTermLength = 64
+	// maxQueryTerms limits the number of words used from a search query.
+	maxQueryTerms = 8
+)
+
+var termRegexp = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*|[0-9a-f]{7,}`)
+
+// searchTerms splits text in identifiers and returns the number of times
+// each one appears. Terms are lowercased so searches are case insensitive.
+func searchTerms(text string) map[string]int {
+	terms := make(map[string]int)
+	for _, t := range termRegexp.FindAllString(text, -1) {
+		if len(t) < 2 || len(t) > maxTermLength {
+			continue
+		}
+
+		terms[strings.ToLower(t)]++
+	}
+
+	return terms
+}
+
+// effectTerms returns the search terms of the user and last version code of
+// the effect.
+func effectTerms(e *Effect) map[string]int {
+	var code string
+	if len(e.Versions) > 0 {
+		code = e.Versions[len(e.Versions)-1].Code
+	}
+
+	// the user is split like the queries so names that are not identifiers
+	// can be found and long ones fit in the index
+	terms := searchTerms(code)
+	for t, n := range searchTerms(e.User) {
+		terms[t] += n
+	}
+
+	return terms
+}
+
+// queryTerms returns the unique terms of a search query sorted
+// alphabetically.
+func queryTerms(query string) []string {
+	var terms []string
+	for t := range searchTerms(query) {
+		terms = append(terms, t)
+	}
+	sort.Strings(terms)
+
+	if len(terms) > maxQueryTerms {
+		terms = terms[:maxQueryTerms]
+	}
+
+	return terms
+}
+
+// searchTerm is a row of the search index. It holds the number of times a
+// term appears in an effect.
+type searchTerm struct {
+	Term     string `gorm:"primary_key;size:64"`
+	EffectID uint   `gorm:"primary_key;auto_increment:false;index:search_effect_id"`
+	Count    int
+}
+
+// searchInsertRows is the number of rows inserted per statement. It is kept
+// below the sqlite limit of 999 variables.
+const searchInsertRows = 300
+
+// IndexEffect replaces the search terms of the effect with the ones from its
+// user and last version.
+func (d *Database) IndexEffect(e *Effect) error {
+	err := d.Where("effect_id = ?", e.ID).Delete(&searchTerm{}).Error
+	if err != nil {
+		d.log().Errorf(err, "cannot delete search terms of %v", e.ID)
+		return err
+	}
+
+	terms := effectTerms(e)
+	names := make([]string, 0, len(terms))
+	for t := range terms {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+
+	for len(names) > 0 {
+		n := len(names)
+		if n > searchInsertRows {
+			n = searchInsertRows
+		}
+
+		values := make([]string, 0, n)
+		args := make([]interface{}, 0, n*3)
+		for _, t := range names[:n] {
+			values = append(values, "(?, ?, ?)")
+			args = append(args, t, e.ID, terms[t])
+		}
+		names = names[n:]
+
+		err = d.Exec("INSERT INTO search_terms (term, effect_id, count) VALUES "+
+			strings.Join(values, ", "), args...).Error
+		if err != nil {
+			d.log().Errorf(err, "cannot index effect %v", e.ID)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reindex rebuilds the search index of all the effects.
+func (d *Database) Reindex() error {
+	const batch = 100
+
+	var lastID uint
+	for {
+		var effects []Effect
+		err := d.Where("id > ?", lastID).Order("id").Limit(batch).
+			Preload("Versions", func(db *gorm.DB) *gorm.DB {
+				return db.Order("number")
+			}).Find(&effects).Error
+		if err != nil {
+			d.log().Errorf(err, "cannot retrieve effects after %v", lastID)
+			return err
+		}
+
+		if len(effects) == 0 {
+			return nil
+		}
+
+		for i := range effects {
+			err = d.IndexEffect(&effects[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		lastID = effects[len(effects)-1].ID
+		d.log().Infof("indexed effects up to %v", lastID)
+	}
+}
+
+type searchResult struct {
+	EffectID uint
+	Score    int
+}
+
+func (d *Database) Search(query string, page, size int) ([]Effect, int, error) {
+	terms := queryTerms(query)
+	if len(terms) == 0 {
+		return nil, 0, nil
+	}
+
+	matches := d.Table("search_terms").Select("effect_id").
+		Where("term IN (?)", terms).
+		Group("effect_id").
+		Having("COUNT(*) = ?", len(terms))
+
+	var total int
+	err := d.Raw("SELECT COUNT(*) FROM (?) AS matches", matches.SubQuery()).
+		Row().Scan(&total)
+	if err != nil {
+		d.log().Errorf(err, "cannot count search results for %q", query)
+		return nil, 0, err
+	}
+
+	var results []searchResult
+	err = matches.Select("effect_id, SUM(count) AS score").
+		Order("score desc, effect_id desc").
+		Limit(size).Offset(page * size).
+		Scan(&results).Error
+	if err != nil {
+		d.log().Errorf(err, "cannot search %q", query)
+		return nil, 0, err
+	}
+
+	if len(results) == 0 {
+		return nil, total, nil
+	}
+
+	ids := make([]uint, 0, len(results))
+	for _, r := range results {
+		ids = append(ids, r.EffectID)
+	}
+
+	var found []Effect
+	err = d.Where("id IN (?)", ids).
+		Preload("Versions", func(db *gorm.DB) *gorm.DB {
+			return db.Order("number")
+		}).Find(&found).Error
+	if err != nil {
+		d.log().Errorf(err, "cannot retrieve search results for %q", query)
+		return nil, 0, err
+	}
+
+	byID := make(map[uint]Effect, len(found))
+	for _, e := range found {
+		byID[e.ID] = e
+	}
+
+	effects := make([]Effect, 0, len(ids))
+	for _, id := range ids {
+		if e, ok := byID[id]; ok {
+			effects = append(effects, e)
+		}
+	}
+
+	return effects, total, nil
+}
@@ -0,0 +1,172 @@
+package glsl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchTerms(t *testing.T) {
+	require := require.New(t)
+
+	terms := searchTerms("float sdRoundBox(vec3 p) {\n\t// rounded box\n\treturn sdRoundBox(p) + 1.0; }")
+	require.Equal(map[string]int{
+		"float":      1,
+		"sdroundbox": 2,
+		"vec3":       1,
+		"rounded":    1,
+		"box":        1,
+		"return":     1,
+	}, terms)
+
+	require.Equal([]string{"4900bbd", "sdroundbox"},
+		queryTerms("sdRoundBox 4900bbd SDROUNDBOX p"))
+	require.Empty(queryTerms("  ( ) "))
+
+	long := strings.Repeat("a", maxTermLength+1)
+	terms = effectTerms(&Effect{
+		User:     "Jane.Doe-" + long,
+		Versions: []Version{{Code: "jane"}},
+	})
+	require.Equal(map[string]int{"jane": 2, "doe": 1}, terms)
+}
+
+func TestSearchMemory(t *testing.T) {
+	testSearch(t, NewMemoryStore())
+}
+
+func TestSearchSQLite(t *testing.T) {
+	db := testDatabase(t)
+	defer db.Close()
+
+	err := db.Migrate(LatestSchema())
+	require.NoError(t, err)
+
+	testSearch(t, db)
+
+	// rebuilding the index does not change the results
+	err = db.Reindex()
+	require.NoError(t, err)
+
+	effects, total, err := db.Search("sdSphere", 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, 2, total)
+	require.Len(t, effects, 2)
+}
+
+func testSearch(t *testing.T, store Store) {
+	require := require.New(t)
+
+	codes := []struct {
+		user string
+		code []string
+	}{
+		{"4900bbd", []string{"float sdSphere(vec3 p)", "float sdRoundBox(vec3 p)"}},
+		{"d4dd013", []string{"float sdSphere(vec3 p) { return sdSphere(p); }"}},
+		{"4900bbd", []string{"void main(void) { gl_FragColor = vec4(0.0); }"}},
+	}
+
+	for _, c := range codes {
+		e, err := store.NewEffect(0, 0, c.user, "")
+		require.NoError(err)
+
+		for _, code := range c.code {
+			_, err = store.AddVersion(e, code)
+			require.NoError(err)
+		}
+	}
+
+	// only the last version is indexed, effect 2 uses sdSphere twice
+	effects, total, err := store.Search("sdsphere", 0, 10)
+	require.NoError(err)
+	require.Equal(1, total)
+	require.Len(effects, 1)
+	require.Equal(uint(2), effects[0].ID)
+
+	effects, total, err = store.Search("sdRoundBox", 0, 10)
+	require.NoError(err)
+	require.Equal(1, total)
+	require.Equal(uint(1), effects[0].ID)
+	require.Len(effects[0].Versions, 2)
+
+	// all the words must match
+	effects, total, err = store.Search("4900bbd vec3", 0, 10)
+	require.NoError(err)
+	require.Equal(1, total)
+	require.Equal(uint(1), effects[0].ID)
+
+	effects, total, err = store.Search("4900bbd", 0, 1)
+	require.NoError(err)
+	require.Equal(2, total)
+	require.Len(effects, 1)
+
+	effects, total, err = store.Search("4900bbd", 1, 1)
+	require.NoError(err)
+	require.Equal(2, total)
+	require.Len(effects, 1)
+
+	effects, total, err = store.Search("missing", 0, 10)
+	require.NoError(err)
+	require.Equal(0, total)
+	require.Len(effects, 0)
+
+	// a new version replaces the indexed code
+	e, err := store.Effect(1)
+	require.NoError(err)
+	_, err = store.AddVersion(e, "float sdSphere(vec3 p)")
+	require.NoError(err)
+
+	effects, total, err = store.Search("sdSphere", 0, 10)
+	require.NoError(err)
+	require.Equal(2, total)
+	require.Equal(uint(2), effects[0].ID)
+	require.Equal(uint(1), effects[1].ID)
+
+	// users are split in words like the queries
+	user := "Jane.Doe-" + strings.Repeat("a", 250)
+	e, err = store.NewEffect(0, 0, user, "")
+	require.NoError(err)
+	_, err = store.AddVersion(e, "void main(void) {}")
+	require.NoError(err)
+
+	effects, total, err = store.Search("jane.doe", 0, 10)
+	require.NoError(err)
+	require.Equal(1, total)
+	require.Equal(user, effects[0].User)
+}
+
+func TestSearchHandlers(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	for _, code := range []string{"sdSphere", "sdRoundBox", "sdSphere sdSphere"} {
+		e, err := store.NewEffect(0, 0, "user", "")
+		require.NoError(err)
+		_, err = store.AddVersion(e, code)
+		require.NoError(err)
+	}
+
+	var effects apiEffects
+	status := apiRequest(t, h, "GET", "/api/v1/effects?q=sdsphere", nil, &effects)
+	require.Equal(http.StatusOK, status)
+	require.Equal("sdsphere", effects.Query)
+	require.Equal(2, effects.Total)
+	require.Equal(uint(3), effects.Effects[0].ID)
+	require.Equal(uint(1), effects.Effects[1].ID)
+
+	req := httptest.NewRequest("GET", "/?q=sdRoundBox", nil)
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	require.Equal(http.StatusOK, res.Code)
+	body := res.Body.String()
+	require.Contains(body, "/e#2.0")
+	require.NotContains(body, "/e#1.0")
+	require.Contains(body, "&q=sdRoundBox")
+}
@@ -0,0 +1,550 @@
+package glsl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// shadertoyURL is the address of the shaders in Shadertoy, recorded as the
+// source of the imported versions.
+const shadertoyURL = "https://www.shadertoy.com/view/"
+
+// shadertoyImageOutput is the id Shadertoy gives to the output of the image
+// pass.
+const shadertoyImageOutput = "4dfGRr"
+
+// ShadertoyShader is a shader in the JSON format of the Shadertoy API and
+// exports.
+type ShadertoyShader struct {
+	Version    string          `json:"ver"`
+	Info       ShadertoyInfo   `json:"info"`
+	RenderPass []ShadertoyPass `json:"renderpass"`
+}
+
+type ShadertoyInfo struct {
+	ID string `json:"id"`
+	// Date is the creation time in seconds since the epoch.
+	Date        string   `json:"date"`
+	Name        string   `json:"name"`
+	Username    string   `json:"username"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+}
+
+type ShadertoyPass struct {
+	Inputs      []ShadertoyInput  `json:"inputs"`
+	Outputs     []ShadertoyOutput `json:"outputs"`
+	Code        string            `json:"code"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	// Type is image, buffer, common, sound or cubemap.
+	Type string `json:"type"`
+}
+
+type ShadertoyInput struct {
+	Channel int    `json:"channel"`
+	CType   string `json:"ctype"`
+	Src     string `json:"src,omitempty"`
+}
+
+type ShadertoyOutput struct {
+	// ID is a number in old exports and a string in new ones.
+	ID      interface{} `json:"id"`
+	Channel int         `json:"channel"`
+}
+
+// UnsupportedShaderError is returned when a shader uses features that cannot
+// be converted.
+type UnsupportedShaderError struct {
+	Features []string
+}
+
+func (e *UnsupportedShaderError) Error() string {
+	return "unsupported features: " + strings.Join(e.Features, ", ")
+}
+
+// UnsupportedShader is a shader not converted and the features that
+// prevented it.
+type UnsupportedShader struct {
+	ID       string   `json:"id"`
+	Features []string `json:"features"`
+}
+
+// ShadertoyReport counts the shaders processed by ImportShadertoy and
+// ExportShadertoy.
+type ShadertoyReport struct {
+	Converted int
+	// Skipped counts the shaders already imported.
+	Skipped     int
+	Failed      int
+	Unsupported []UnsupportedShader
+}
+
+func (r *ShadertoyReport) unsupported(id string, err error) bool {
+	u, ok := err.(*UnsupportedShaderError)
+	if ok {
+		r.Unsupported = append(r.Unsupported, UnsupportedShader{
+			ID:       id,
+			Features: u.Features,
+		})
+	}
+
+	return ok
+}
+
+// ReadShadertoy decodes the shaders of a Shadertoy API response, with the
+// shader in the Shader field, a single shader or a list of them as in the
+// exports of all the shaders of a user.
+func ReadShadertoy(r io.Reader) ([]ShadertoyShader, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var shaders []ShadertoyShader
+		err = json.Unmarshal(data, &shaders)
+		return shaders, err
+	}
+
+	var response struct {
+		Shader *ShadertoyShader
+		Error  string
+	}
+	err = json.Unmarshal(data, &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("shadertoy error: %v", response.Error)
+	}
+	if response.Shader != nil {
+		return []ShadertoyShader{*response.Shader}, nil
+	}
+
+	var shader ShadertoyShader
+	err = json.Unmarshal(data, &shader)
+	if err != nil {
+		return nil, err
+	}
+
+	return []ShadertoyShader{shader}, nil
+}
+
+// FromShadertoy converts a single pass Shadertoy shader into an effect with
+// one version. It returns an UnsupportedShaderError if it has several passes,
+// inputs or uses uniforms without equivalent. The mainImage entry point is
+// kept and called from a main function with gl_FragCoord instead of
+// replacing fragCoord in its code, see shadertoyMain.
+func FromShadertoy(s *ShadertoyShader) (*Effect, error) {
+	if s.Info.ID == "" {
+		return nil, fmt.Errorf("shader without id")
+	}
+
+	var features []string
+	var image *ShadertoyPass
+	for i, p := range s.RenderPass {
+		if p.Type != "image" {
+			features = append(features, p.Type+" pass")
+			continue
+		}
+		if image != nil {
+			features = append(features, "several image passes")
+			continue
+		}
+
+		image = &s.RenderPass[i]
+		for _, input := range p.Inputs {
+			features = append(features,
+				fmt.Sprintf("iChannel%d %v input", input.Channel, input.CType))
+		}
+	}
+
+	if image == nil {
+		return nil, &UnsupportedShaderError{
+			Features: append(features, "no image pass"),
+		}
+	}
+
+	code, unsupported := fromShadertoyCode(image.Code)
+	features = append(features, unsupported...)
+	if len(features) > 0 {
+		return nil, &UnsupportedShaderError{Features: features}
+	}
+
+	created := time.Now()
+	if seconds, err := strconv.ParseInt(s.Info.Date, 10, 64); err == nil {
+		created = time.Unix(seconds, 0)
+	}
+
+	source := shadertoyURL + s.Info.ID
+	header := fmt.Sprintf("// %v by %v\n// %v\n\n",
+		oneLine(s.Info.Name), oneLine(s.Info.Username), source)
+
+	return &Effect{
+		Created:  created,
+		Modified: created,
+		User:     s.Info.Username,
+		Versions: []Version{{
+			Created: created,
+			Code:    header + code,
+			Source:  source,
+		}},
+	}, nil
+}
+
+// ToShadertoy converts the last version of the effect into a Shadertoy
+// shader. It returns an UnsupportedShaderError if it uses the backbuffer or
+// the surface position.
+func ToShadertoy(e *Effect) (*ShadertoyShader, error) {
+	if len(e.Versions) == 0 {
+		return nil, fmt.Errorf("effect %v without versions", e.ID)
+	}
+
+	version := e.LastVersion()
+	code, unsupported := toShadertoyCode(e.Versions[version].Code)
+	if len(unsupported) > 0 {
+		return nil, &UnsupportedShaderError{Features: unsupported}
+	}
+
+	return &ShadertoyShader{
+		Version: "0.1",
+		Info: ShadertoyInfo{
+			Date:     strconv.FormatInt(e.Modified.Unix(), 10),
+			Name:     fmt.Sprintf("glslsandbox %v", e.ID),
+			Username: e.User,
+			Description: fmt.Sprintf("Exported from glslsandbox effect %v.%v",
+				e.ID, version),
+			Tags: []string{},
+		},
+		RenderPass: []ShadertoyPass{{
+			Inputs: []ShadertoyInput{},
+			Outputs: []ShadertoyOutput{
+				{ID: shadertoyImageOutput, Channel: 0},
+			},
+			Code: code,
+			Name: "Image",
+			Type: "image",
+		}},
+	}, nil
+}
+
+// ImportShadertoy stores the supported shaders as new effects. Shaders
+// already imported, with a version with the same source, are skipped.
+func (d *Database) ImportShadertoy(shaders []ShadertoyShader) (*ShadertoyReport, error) {
+	report := new(ShadertoyReport)
+
+	for i := range shaders {
+		s := &shaders[i]
+		logger := d.log().With(log.Fields{"shader": s.Info.ID})
+
+		e, err := FromShadertoy(s)
+		if report.unsupported(s.Info.ID, err) {
+			logger.Warningf("cannot convert shader: %v", err)
+			continue
+		}
+		if err != nil {
+			logger.Errorf(err, "cannot convert shader")
+			report.Failed++
+			continue
+		}
+
+		var count int
+		err = d.Model(&Version{}).Where("source = ?", e.Versions[0].Source).
+			Count(&count).Error
+		if err != nil {
+			logger.Errorf(err, "cannot find imported shader")
+			report.Failed++
+			continue
+		}
+		if count > 0 {
+			report.Skipped++
+			continue
+		}
+
+		err = d.transaction(func(tx *Database) error {
+			return tx.ImportEffect(e)
+		})
+		if err != nil {
+			report.Failed++
+			continue
+		}
+
+		report.Converted++
+	}
+
+	return report, nil
+}
+
+// ExportShadertoy writes the effects selected by the filter as a list of
+// Shadertoy shaders, in the format of the exports of all the shaders of a
+// user. The effects that cannot be converted are left out.
+func (d *Database) ExportShadertoy(
+	w io.Writer,
+	filter ExportFilter,
+) (*ShadertoyReport, error) {
+	report := new(ShadertoyReport)
+	shaders := []*ShadertoyShader{}
+
+	err := d.exportEach(filter, func(e *Effect) error {
+		s, err := ToShadertoy(e)
+		id := strconv.FormatUint(uint64(e.ID), 10)
+		if report.unsupported(id, err) {
+			d.log().With(log.Fields{"effect": e.ID}).
+				Warningf("cannot convert effect: %v", err)
+			return nil
+		}
+		if err != nil {
+			report.Failed++
+			return nil
+		}
+
+		shaders = append(shaders, s)
+		report.Converted++
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return report, enc.Encode(shaders)
+}
+
+var (
+	identifierRegexp = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+	// commentRegexp matches line and block comments, unterminated block
+	// comments end with the code.
+	commentRegexp = regexp.MustCompile(`(?s)//[^\n]*|/\*.*?(\*/|$)`)
+	// swizzleRegexp matches the components selected after an identifier.
+	swizzleRegexp   = regexp.MustCompile(`^\s*\.\s*([xyzwrgbastpq]{1,4})\b`)
+	mainImageRegexp = regexp.MustCompile(`\bvoid\s+mainImage\s*\(`)
+	mainRegexp      = regexp.MustCompile(`\bvoid\s+main\s*\(\s*(void)?\s*\)`)
+	// shadertoyMainRegexp matches the main added by fromShadertoyCode.
+	shadertoyMainRegexp = regexp.MustCompile(`\s*\bvoid\s+main\s*\(\s*(void)?\s*\)` +
+		`\s*\{\s*mainImage\s*\(\s*gl_FragColor\s*,\s*gl_FragCoord\.xy\s*\)\s*;\s*\}`)
+	// sandboxDeclarations are the lines declaring the uniforms given by
+	// glslsandbox and the extension it enables, not needed in Shadertoy.
+	sandboxDeclarations = regexp.MustCompile(
+		`(?m)^[ \t]*(uniform\s+(\w+\s+)?\w+\s+(time|mouse|resolution)\s*;|` +
+			`#extension\s+GL_OES_standard_derivatives\s*:\s*\w+)[ \t]*\r?\n?`)
+)
+
+// shadertoyExpressions are the replacements of the Shadertoy uniforms made
+// by fromShadertoyCode, restored by toShadertoyCode.
+var shadertoyExpressions = strings.NewReplacer(
+	"vec4(mouse * resolution, 0.0, 0.0)", "iMouse",
+	"vec3(resolution, 1.0)", "iResolution",
+)
+
+// shadertoyUnsupported are the Shadertoy uniforms without an equivalent in
+// glslsandbox.
+var shadertoyUnsupported = map[string]bool{
+	"iTimeDelta":         true,
+	"iFrame":             true,
+	"iFrameRate":         true,
+	"iChannelTime":       true,
+	"iChannelResolution": true,
+	"iChannel0":          true,
+	"iChannel1":          true,
+	"iChannel2":          true,
+	"iChannel3":          true,
+	"iDate":              true,
+	"iSampleRate":        true,
+}
+
+// sandboxUnsupported are the glslsandbox inputs without an equivalent in
+// Shadertoy.
+var sandboxUnsupported = map[string]bool{
+	"backbuffer":      true,
+	"surfacePosition": true,
+	"surfaceSize":     true,
+}
+
+// shadertoyHeader declares the glslsandbox uniforms used by the converted
+// Shadertoy code.
+const shadertoyHeader = `#ifdef GL_ES
+precision highp float;
+#endif
+
+uniform float time;
+uniform vec2 mouse;
+uniform vec2 resolution;
+
+`
+
+// shadertoyMain calls the Shadertoy entry point. Passing gl_FragCoord to
+// mainImage is the same as replacing fragCoord with it but also works when
+// the parameters have other names or helper functions use the same ones.
+// toShadertoyCode removes it to restore the original entry point.
+const shadertoyMain = `
+
+void main(void) {
+	mainImage(gl_FragColor, gl_FragCoord.xy);
+}
+`
+
+// fromShadertoyCode converts Shadertoy code into glslsandbox code replacing
+// iTime, iResolution and iMouse with time, resolution and mouse. fragCoord
+// is left as is, shadertoyMain passes gl_FragCoord to mainImage. It returns
+// the features that could not be converted.
+func fromShadertoyCode(code string) (string, []string) {
+	var unsupported []string
+	if !mainImageRegexp.MatchString(code) {
+		unsupported = append(unsupported, "no mainImage entry point")
+	}
+
+	found := make(map[string]bool)
+	code = replaceIdentifiers(code, func(name, after string) (string, int) {
+		switch name {
+		case "iTime", "iGlobalTime":
+			return "time", 0
+		case "iResolution":
+			// resolution only has the first two components of iResolution
+			if m := swizzleRegexp.FindStringSubmatch(after); m != nil &&
+				strings.Trim(m[1], "xyrgst") == "" {
+				return "resolution." + m[1], len(m[0])
+			}
+			return "vec3(resolution, 1.0)", 0
+		case "iMouse":
+			// the mouse is never considered pressed
+			return "vec4(mouse * resolution, 0.0, 0.0)", 0
+		}
+
+		if shadertoyUnsupported[name] {
+			found[name] = true
+		}
+		return name, 0
+	})
+
+	return shadertoyHeader + strings.TrimSpace(code) + shadertoyMain,
+		append(unsupported, sortedKeys(found)...)
+}
+
+// toShadertoyCode converts glslsandbox code into Shadertoy code replacing
+// main with mainImage and time, resolution, mouse, gl_FragColor and
+// gl_FragCoord with their equivalents. It returns the features that could
+// not be converted.
+func toShadertoyCode(code string) (string, []string) {
+	var unsupported []string
+	if !mainRegexp.MatchString(code) {
+		unsupported = append(unsupported, "no main entry point")
+	}
+
+	code = sandboxDeclarations.ReplaceAllString(code, "")
+
+	// code converted from Shadertoy already has mainImage
+	if mainImageRegexp.MatchString(code) &&
+		shadertoyMainRegexp.MatchString(code) {
+		code = shadertoyMainRegexp.ReplaceAllString(code, "")
+		code = shadertoyExpressions.Replace(code)
+	} else {
+		code = mainRegexp.ReplaceAllString(code,
+			"void mainImage(out vec4 fragColor, in vec2 fragCoord)")
+	}
+
+	found := make(map[string]bool)
+	code = replaceIdentifiers(code, func(name, after string) (string, int) {
+		switch name {
+		case "time":
+			return "iTime", 0
+		case "resolution":
+			if m := swizzleRegexp.FindStringSubmatch(after); m != nil {
+				return "iResolution." + m[1], len(m[0])
+			}
+			return "iResolution.xy", 0
+		case "mouse":
+			return "(iMouse.xy / iResolution.xy)", 0
+		case "gl_FragColor":
+			return "fragColor", 0
+		case "gl_FragCoord":
+			// gl_FragCoord also exists in Shadertoy, only its first two
+			// components are in fragCoord
+			if m := swizzleRegexp.FindStringSubmatch(after); m != nil &&
+				strings.Trim(m[1], "xyrgst") == "" {
+				return "fragCoord." + m[1], len(m[0])
+			}
+			return name, 0
+		case "texture2D":
+			return "texture", 0
+		}
+
+		if sandboxUnsupported[name] {
+			found[name] = true
+		}
+		return name, 0
+	})
+
+	return strings.TrimSpace(code) + "\n", append(unsupported, sortedKeys(found)...)
+}
+
+// replaceIdentifiers calls replace with each identifier of the code, not
+// preceded by a dot or inside a comment, and the text after it. It returns
+// the replacement and the number of bytes of the text after it also
+// replaced.
+func replaceIdentifiers(
+	code string,
+	replace func(name, after string) (string, int),
+) string {
+	var buf strings.Builder
+	last := 0
+	comments := commentRegexp.FindAllStringIndex(code, -1)
+
+	for _, loc := range identifierRegexp.FindAllStringIndex(code, -1) {
+		start, end := loc[0], loc[1]
+		if start < last {
+			continue
+		}
+
+		for len(comments) > 0 && comments[0][1] <= start {
+			comments = comments[1:]
+		}
+		if len(comments) > 0 && comments[0][0] <= start {
+			continue
+		}
+
+		// members of structs and exponents of numbers like 1e5
+		if start > 0 {
+			prev := code[start-1]
+			if prev == '.' || (prev >= '0' && prev <= '9') {
+				continue
+			}
+		}
+
+		name := code[start:end]
+		replacement, n := replace(name, code[end:])
+		buf.WriteString(code[last:start])
+		buf.WriteString(replacement)
+		last = end + n
+	}
+
+	buf.WriteString(code[last:])
+	return buf.String()
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// oneLine replaces the line breaks of the text so it fits in a comment.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
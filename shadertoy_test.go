@@ -0,0 +1,294 @@
+package glsl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const shadertoyCode = `void mainImage( out vec4 fragColor, in vec2 fragCoord )
+{
+    vec2 uv = fragCoord/iResolution.xy;
+    vec2 m = iMouse.xy/iResolution.xy;
+    float aspect = iResolution.x / iResolution.y;
+    vec3 col = 0.5 + 0.5*cos(iTime+uv.xyx+vec3(0,2,4)) * 1e1;
+    fragColor = vec4(col * iResolution.z * aspect, 1.0) + m.x;
+}`
+
+const sandboxCode = `#ifdef GL_ES
+precision mediump float;
+#endif
+
+#extension GL_OES_standard_derivatives : enable
+
+uniform float time;
+uniform vec2 mouse;
+uniform highp vec2 resolution;
+
+void main( void ) {
+	vec2 p = gl_FragCoord.xy / resolution;
+	float d = length(p - mouse) * resolution.x + gl_FragCoord.z;
+	vec4 c = vec4(time);
+	gl_FragColor = texture2D(sampler, p) + vec4(d * c.x);
+}`
+
+func testShadertoy(id string, code string) ShadertoyShader {
+	return ShadertoyShader{
+		Version: "0.1",
+		Info: ShadertoyInfo{
+			ID:       id,
+			Date:     "1562492504",
+			Name:     "Shader\nname",
+			Username: "user",
+		},
+		RenderPass: []ShadertoyPass{{
+			Code: code,
+			Name: "Image",
+			Type: "image",
+		}},
+	}
+}
+
+func TestFromShadertoyCode(t *testing.T) {
+	require := require.New(t)
+
+	code, unsupported := fromShadertoyCode(shadertoyCode)
+	require.Empty(unsupported)
+
+	require.True(strings.HasPrefix(code, shadertoyHeader))
+	require.True(strings.HasSuffix(code, shadertoyMain))
+	require.Contains(code, "vec2 uv = fragCoord/resolution.xy;")
+	require.Contains(code, "vec2 m = vec4(mouse * resolution, 0.0, 0.0).xy/resolution.xy;")
+	require.Contains(code, "float aspect = resolution.x / resolution.y;")
+	require.Contains(code, "cos(time+uv.xyx+vec3(0,2,4)) * 1e1;")
+	require.Contains(code, "col * vec3(resolution, 1.0).z * aspect")
+	require.NotContains(code, "iTime")
+
+	_, unsupported = fromShadertoyCode(`void mainImage(out vec4 c, in vec2 p) {
+		c = texture(iChannel0, p / iResolution.xy) * float(iFrame) + iDate;
+		c += texture(iChannel0, p);
+	}`)
+	require.Equal([]string{"iChannel0", "iDate", "iFrame"}, unsupported)
+
+	_, unsupported = fromShadertoyCode(`void main() {}`)
+	require.Equal([]string{"no mainImage entry point"}, unsupported)
+
+	// comments are not converted
+	code, unsupported = fromShadertoyCode(`// uses iTime and iFrame
+void mainImage(out vec4 c, in vec2 p) {
+	/* iChannel0
+	   iMouse */ c = vec4(iTime); // iTime
+	c.x += iResolution.x; /* iDate`)
+	require.Empty(unsupported)
+	require.Contains(code, "// uses iTime and iFrame\n")
+	require.Contains(code, "/* iChannel0\n\t   iMouse */ c = vec4(time); // iTime\n")
+	require.Contains(code, "c.x += resolution.x; /* iDate")
+}
+
+func TestToShadertoyCode(t *testing.T) {
+	require := require.New(t)
+
+	code, unsupported := toShadertoyCode(sandboxCode)
+	require.Empty(unsupported)
+
+	require.Equal(`#ifdef GL_ES
+precision mediump float;
+#endif
+
+
+
+void mainImage(out vec4 fragColor, in vec2 fragCoord) {
+	vec2 p = fragCoord.xy / iResolution.xy;
+	float d = length(p - (iMouse.xy / iResolution.xy)) * iResolution.x + gl_FragCoord.z;
+	vec4 c = vec4(iTime);
+	fragColor = texture(sampler, p) + vec4(d * c.x);
+}
+`, code)
+
+	_, unsupported = toShadertoyCode(`uniform sampler2D backbuffer;
+	varying vec2 surfacePosition;
+	void main() {
+		gl_FragColor = texture2D(backbuffer, surfacePosition);
+	}`)
+	require.Equal([]string{"backbuffer", "surfacePosition"}, unsupported)
+
+	_, unsupported = toShadertoyCode(`void mainImage() {}`)
+	require.Equal([]string{"no main entry point"}, unsupported)
+
+	code, _ = toShadertoyCode("void main() {\n\t// time\n\tgl_FragColor = vec4(time);\n}")
+	require.Equal("void mainImage(out vec4 fragColor, in vec2 fragCoord) {\n"+
+		"\t// time\n\tfragColor = vec4(iTime);\n}\n", code)
+}
+
+func TestShadertoyRoundTrip(t *testing.T) {
+	require := require.New(t)
+
+	s := testShadertoy("abcdef", shadertoyCode)
+	e, err := FromShadertoy(&s)
+	require.NoError(err)
+
+	require.Equal("user", e.User)
+	require.True(time.Unix(1562492504, 0).Equal(e.Created))
+	require.Len(e.Versions, 1)
+	require.Equal("https://www.shadertoy.com/view/abcdef", e.Versions[0].Source)
+	require.True(strings.HasPrefix(e.Versions[0].Code,
+		"// Shader name by user\n// https://www.shadertoy.com/view/abcdef\n"))
+
+	e.ID = 10
+	exported, err := ToShadertoy(e)
+	require.NoError(err)
+	require.Equal("glslsandbox 10", exported.Info.Name)
+	require.Len(exported.RenderPass, 1)
+
+	pass := exported.RenderPass[0]
+	require.Equal("image", pass.Type)
+	require.Equal([]ShadertoyOutput{{ID: shadertoyImageOutput}}, pass.Outputs)
+	require.True(strings.HasSuffix(pass.Code, shadertoyCode+"\n"), pass.Code)
+}
+
+func TestReadShadertoy(t *testing.T) {
+	require := require.New(t)
+
+	tests := []struct {
+		name string
+		json string
+		ids  []string
+	}{
+		{
+			name: "api",
+			json: `{"Shader": {"ver": "0.1", "info": {"id": "a"}, "renderpass": [` +
+				`{"outputs": [{"id": 37, "channel": 0}], "type": "image"}]}}`,
+			ids: []string{"a"},
+		},
+		{
+			name: "shader",
+			json: `{"ver": "0.1", "info": {"id": "a"}}`,
+			ids:  []string{"a"},
+		},
+		{
+			name: "list",
+			json: ` [{"info": {"id": "a"}}, {"info": {"id": "b"}}]`,
+			ids:  []string{"a", "b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shaders, err := ReadShadertoy(strings.NewReader(test.json))
+			require.NoError(err)
+
+			var ids []string
+			for _, s := range shaders {
+				ids = append(ids, s.Info.ID)
+			}
+			require.Equal(test.ids, ids)
+		})
+	}
+
+	_, err := ReadShadertoy(strings.NewReader(`{"Error": "Shader not found"}`))
+	require.EqualError(err, "shadertoy error: Shader not found")
+	_, err = ReadShadertoy(strings.NewReader(`{invalid`))
+	require.Error(err)
+}
+
+func TestImportShadertoy(t *testing.T) {
+	require := require.New(t)
+
+	db := migratedDatabase(t)
+	defer db.Close()
+
+	buffer := testShadertoy("buffer", shadertoyCode)
+	buffer.RenderPass = append(buffer.RenderPass, ShadertoyPass{Type: "buffer"})
+	input := testShadertoy("input", shadertoyCode)
+	input.RenderPass[0].Inputs = []ShadertoyInput{{Channel: 1, CType: "texture"}}
+
+	shaders := []ShadertoyShader{
+		testShadertoy("valid", shadertoyCode),
+		buffer,
+		input,
+		testShadertoy("frame", strings.Replace(shadertoyCode, "iTime", "iFrame", 1)),
+		testShadertoy("", shadertoyCode),
+	}
+
+	report, err := db.ImportShadertoy(shaders)
+	require.NoError(err)
+	require.Equal(&ShadertoyReport{
+		Converted: 1,
+		Failed:    1,
+		Unsupported: []UnsupportedShader{
+			{ID: "buffer", Features: []string{"buffer pass"}},
+			{ID: "input", Features: []string{"iChannel1 texture input"}},
+			{ID: "frame", Features: []string{"iFrame"}},
+		},
+	}, report)
+
+	effects, err := db.Effects(0, 10)
+	require.NoError(err)
+	require.Len(effects, 1)
+
+	e, err := db.Effect(int(effects[0].ID))
+	require.NoError(err)
+	require.Equal("user", e.User)
+	require.Equal("https://www.shadertoy.com/view/valid", e.Versions[0].Source)
+
+	results, _, err := db.Search("mainImage", 0, 10)
+	require.NoError(err)
+	require.Len(results, 1)
+
+	// already imported
+	report, err = db.ImportShadertoy(shaders[:1])
+	require.NoError(err)
+	require.Equal(&ShadertoyReport{Skipped: 1}, report)
+
+	require.NoError(db.ImportEffect(&Effect{
+		ID:       100,
+		Created:  time.Now(),
+		Modified: time.Now(),
+		Versions: []Version{{Code: sandboxCode}},
+	}))
+	require.NoError(db.ImportEffect(&Effect{
+		ID:       101,
+		Created:  time.Now(),
+		Modified: time.Now(),
+		Versions: []Version{{Code: "uniform sampler2D backbuffer;\nvoid main() {}"}},
+	}))
+
+	var buf bytes.Buffer
+	report, err = db.ExportShadertoy(&buf, ExportFilter{})
+	require.NoError(err)
+	require.Equal(&ShadertoyReport{
+		Converted: 2,
+		Unsupported: []UnsupportedShader{
+			{ID: "101", Features: []string{"backbuffer"}},
+		},
+	}, report)
+
+	exported, err := ReadShadertoy(&buf)
+	require.NoError(err)
+	require.Len(exported, 2)
+	require.Equal("glslsandbox 100", exported[1].Info.Name)
+	require.Contains(exported[1].RenderPass[0].Code, "void mainImage(")
+
+	// the dump keeps the source of the versions
+	buf.Reset()
+	_, err = db.ExportEffects(&buf, ExportFilter{ToID: e.ID})
+	require.NoError(err)
+	loaded, err := LoadEffect(bytes.TrimSpace(buf.Bytes()))
+	require.NoError(err)
+	require.Equal("https://www.shadertoy.com/view/valid", loaded.Versions[0].Source)
+
+	// errors looking for imported shaders do not stop the import
+	failing := migratedDatabase(t)
+	defer failing.Close()
+	require.NoError(failing.Exec("ALTER TABLE versions RENAME TO old_versions").Error)
+
+	report, err = failing.ImportShadertoy([]ShadertoyShader{
+		testShadertoy("first", shadertoyCode),
+		testShadertoy("second", shadertoyCode),
+	})
+	require.NoError(err)
+	require.Equal(&ShadertoyReport{Failed: 2}, report)
+}
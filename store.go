@@ -0,0 +1,76 @@
+package glsl
+
+import (
+	"errors"
+	"time"
+
+	"gopkg.in/src-d/go-log.v1"
+)
+
+var (
+	// ErrNotFound is returned by Store implementations when the requested
+	// effect does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned by Store implementations when the change
+	// conflicts with other done concurrently. The operation can be retried.
+	ErrConflict = errors.New("conflict")
+)
+
+// Store is the storage backend used by Server to retrieve and save effects
+// and their versions.
+type Store interface {
+	// Effect returns the effect with the given id with all its versions.
+	Effect(id int) (*Effect, error)
+	// Effects returns a page of effects sorted by modification time, newest
+	// first.
+	Effects(page, size int) ([]Effect, error)
+	// Children returns the effects forked from any of the given ones sorted
+	// by id. Their versions do not include the code.
+	Children(ids ...uint) ([]Effect, error)
+	// Search returns a page of the effects matching all the words of the
+	// query in the code of their last version or user, best matches first,
+	// and the total number of matches.
+	Search(query string, page, size int) ([]Effect, int, error)
+	// EffectCount returns the number of stored effects.
+	EffectCount() (int, error)
+	// VersionCount returns the number of stored versions of all effects.
+	VersionCount() (int, error)
+	// LastVersionTime returns the creation time of the last version of the
+	// effect without loading it. ErrNotFound is returned if it has none.
+	LastVersionTime(id int) (time.Time, error)
+	// NewEffect creates a new effect without versions. The user is the name
+	// given by the author and owner its server issued identity.
+	NewEffect(parent, version int, user, owner string) (*Effect, error)
+	// UpdateTime sets the modification time of the effect to now.
+	UpdateTime(e *Effect) error
+	// AddVersion stores code as the next version of the effect. The number
+	// is allocated by the store so concurrent calls get different ones.
+	AddVersion(e *Effect, code string) (*Version, error)
+	// NewAccount stores a new account. ErrConflict is returned if its name
+	// or identity are already used.
+	NewAccount(a *Account) error
+	// Account returns the account with the given name.
+	Account(name string) (*Account, error)
+	// AccountByIdentity returns the account that owns the identity.
+	AccountByIdentity(identity string) (*Account, error)
+	// AccountByLogin returns the account linked to a user of an OAuth
+	// provider.
+	AccountByLogin(provider, subject string) (*Account, error)
+	// AddLogin links a user of an OAuth provider to the account.
+	// ErrConflict is returned if it is already linked.
+	AddLogin(account uint, provider, subject string) error
+	// ClaimEffects changes the owner of the effects of one identity to
+	// other and returns the number of effects changed.
+	ClaimEffects(from, to string) (int, error)
+	// OwnerEffects returns a page of the effects of an owner sorted by
+	// modification time, newest first.
+	OwnerEffects(owner string, page, size int) ([]Effect, error)
+	// Ping checks that the store can be reached.
+	Ping() error
+	// WithLogger returns a Store that logs its errors with the given logger.
+	WithLogger(logger log.Logger) Store
+	// Transaction calls fn with a Store where all the changes are done in a
+	// transaction. The changes are committed if fn returns nil and rolled
+	// back otherwise.
+	Transaction(fn func(Store) error) error
+}
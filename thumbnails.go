@@ -0,0 +1,167 @@
+package glsl
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// maxThumbnailSize is the maximum size of an image extracted from a
+// thumbnails tarball.
+const maxThumbnailSize = 16 << 20
+
+// ThumbnailSource reads the images referenced by the image_url of the
+// effects in the legacy dump.
+type ThumbnailSource interface {
+	// Image returns the content of the image. It returns ErrNotFound if it
+	// does not exist.
+	Image(url string) ([]byte, error)
+	// Close frees the resources used by the source.
+	Close() error
+}
+
+// dirThumbnails is a ThumbnailSource reading the images from a directory.
+type dirThumbnails struct {
+	root string
+	// temp is true when root was created by the source and is deleted on
+	// Close.
+	temp bool
+}
+
+var _ ThumbnailSource = new(dirThumbnails)
+
+// OpenThumbnails opens the thumbnails of the legacy dump from a directory or
+// a tarball, optionally gzip compressed. The tarball is extracted to a
+// temporary directory deleted on Close.
+func OpenThumbnails(p string) (ThumbnailSource, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &dirThumbnails{root: p}, nil
+	}
+
+	dir, err := ioutil.TempDir("", "glsl-thumbnails")
+	if err != nil {
+		return nil, err
+	}
+
+	err = extractThumbnails(p, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return &dirThumbnails{root: dir, temp: true}, nil
+}
+
+// cleanThumbnailPath returns the url as a relative path that cannot point
+// outside the thumbnails directory.
+func cleanThumbnailPath(url string) string {
+	return path.Clean("/" + url)[1:]
+}
+
+// Image reads the image with the path of the url in the directory. If it
+// does not exist the file name is looked up in the root so the directory
+// given can also be the one containing the images.
+func (d *dirThumbnails) Image(url string) ([]byte, error) {
+	name := cleanThumbnailPath(url)
+	if name == "" {
+		return nil, ErrNotFound
+	}
+
+	candidates := []string{
+		filepath.Join(d.root, filepath.FromSlash(name)),
+		filepath.Join(d.root, path.Base(name)),
+	}
+
+	for _, c := range candidates {
+		data, err := ioutil.ReadFile(c)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return data, nil
+	}
+
+	return nil, ErrNotFound
+}
+
+func (d *dirThumbnails) Close() error {
+	if !d.temp {
+		return nil
+	}
+
+	return os.RemoveAll(d.root)
+}
+
+// extractThumbnails writes the regular files of the tarball to dir.
+func extractThumbnails(file, dir string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var tr *tar.Reader
+
+	magic, err := r.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		tr = tar.NewReader(gz)
+	} else {
+		tr = tar.NewReader(r)
+	}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read %v: %v", file, err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := cleanThumbnailPath(header.Name)
+		if name == "" || header.Size > maxThumbnailSize {
+			continue
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("cannot read %v: %v", header.Name, err)
+		}
+
+		err = ioutil.WriteFile(path, data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package glsl
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeTar writes a tarball with the files, gzip compressed if compress is
+// true.
+func writeTar(t *testing.T, path string, files map[string]string, compress bool) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var w io.Writer = f
+	if compress {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+
+	tw := tar.NewWriter(w)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:     "thumbs/",
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+	}))
+
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(content)),
+		}))
+		_, err = tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tw.Close())
+}
+
+func testThumbnails(t *testing.T, source ThumbnailSource) {
+	t.Helper()
+	require := require.New(t)
+
+	data, err := source.Image("thumbs/1.png")
+	require.NoError(err)
+	require.Equal("one", string(data))
+
+	data, err = source.Image("/thumbs/2.png")
+	require.NoError(err)
+	require.Equal("two", string(data))
+
+	// paths cannot go outside the directory
+	data, err = source.Image("../../thumbs/1.png")
+	require.NoError(err)
+	require.Equal("one", string(data))
+
+	_, err = source.Image("thumbs/3.png")
+	require.Equal(ErrNotFound, err)
+	_, err = source.Image("")
+	require.Equal(ErrNotFound, err)
+}
+
+func TestOpenThumbnails(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"./thumbs/1.png": "one",
+		"thumbs/2.png":   "two",
+		"../outside.png": "outside",
+	}
+
+	thumbs := filepath.Join(dir, "dir", "thumbs")
+	require.NoError(os.MkdirAll(thumbs, 0755))
+	for name, content := range files {
+		path := filepath.Join(thumbs, filepath.Base(name))
+		require.NoError(ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "directory", path: filepath.Join(dir, "dir")},
+		{name: "images directory", path: thumbs},
+		{name: "tar", path: filepath.Join(dir, "thumbs.tar")},
+		{name: "tar.gz", path: filepath.Join(dir, "thumbs.tar.gz")},
+	}
+
+	writeTar(t, tests[2].path, files, false)
+	writeTar(t, tests[3].path, files, true)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, err := OpenThumbnails(test.path)
+			require.NoError(err)
+			testThumbnails(t, source)
+
+			d := source.(*dirThumbnails)
+			_, err = os.Stat(filepath.Join(filepath.Dir(d.root), "outside.png"))
+			require.True(os.IsNotExist(err))
+
+			require.NoError(source.Close())
+			_, err = os.Stat(d.root)
+			if d.temp {
+				require.True(os.IsNotExist(err))
+			} else {
+				require.NoError(err)
+			}
+		})
+	}
+
+	_, err = OpenThumbnails(filepath.Join(dir, "none"))
+	require.Error(err)
+
+	invalid := filepath.Join(dir, "invalid.tar")
+	require.NoError(ioutil.WriteFile(invalid, []byte("invalid"), 0644))
+	_, err = OpenThumbnails(invalid)
+	require.Error(err)
+}
@@ -2,77 +2,257 @@ package glsl
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
-	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
-	"github.com/jinzhu/gorm"
+	"github.com/go-chi/chi/middleware"
 	"gopkg.in/src-d/go-log.v1"
 )
 
 const (
-	imagesDir   = "images"
 	galleryPath = "/assets/gallery.html"
+	treePath    = "/assets/tree.html"
 	perPage     = 40
+
+	// DefaultAddress is the address the server listens on when no other is
+	// configured.
+	DefaultAddress = ":3000"
+	// DefaultShutdownTimeout is the time given to in flight requests to
+	// finish when the server is stopped.
+	DefaultShutdownTimeout = 30 * time.Second
 )
 
 type Server struct {
-	db *Database
-	fs http.FileSystem
+	db             Store
+	fs             http.FileSystem
+	images         ImageStore
+	redirectImages bool
+	metrics        *metrics
+	logger         log.Logger
+	assetETags     assetETags
+	gzipAssets     gzipAssets
+	effectLimiter  RateLimiter
+	versionLimiter RateLimiter
+	trustedProxies []*net.IPNet
+	sessionSecret  []byte
+	oauthProviders map[string]OAuthProvider
+
+	addr            string
+	certFile        string
+	keyFile         string
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration
 }
 
-func NewServer(db *gorm.DB, local bool) *Server {
-	return &Server{
-		db: NewDatabase(db),
-		fs: FS(local),
+// ServerOption configures optional features of the Server.
+type ServerOption func(*Server)
+
+// WithImageRedirect makes the server redirect image requests to the address
+// given by the ImageStore, if it supports it, instead of sending the image.
+func WithImageRedirect() ServerOption {
+	return func(s *Server) {
+		s.redirectImages = true
 	}
 }
 
-func (s *Server) Start() {
-	r := chi.NewRouter()
+// WithAddress sets the address the server listens on, by default
+// DefaultAddress.
+func WithAddress(addr string) ServerOption {
+	return func(s *Server) {
+		s.addr = addr
+	}
+}
+
+// WithTLS makes the server use https with the given certificate and key
+// files.
+func WithTLS(certFile, keyFile string) ServerOption {
+	return func(s *Server) {
+		s.certFile = certFile
+		s.keyFile = keyFile
+	}
+}
+
+// WithTimeouts sets the read, write and idle timeouts of the http server. A
+// zero value means no timeout.
+func WithTimeouts(read, write, idle time.Duration) ServerOption {
+	return func(s *Server) {
+		s.readTimeout = read
+		s.writeTimeout = write
+		s.idleTimeout = idle
+	}
+}
+
+// WithShutdownTimeout sets how long the server waits for in flight requests
+// when it is stopped, by default DefaultShutdownTimeout. A zero value waits
+// until all of them finish.
+func WithShutdownTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.shutdownTimeout = d
+	}
+}
+
+func NewServer(
+	db Store,
+	images ImageStore,
+	local bool,
+	opts ...ServerOption,
+) *Server {
+	m := newMetrics(db)
+	s := &Server{
+		db:              &meteredStore{Store: db, m: m},
+		fs:              FS(local),
+		images:          &meteredImages{ImageStore: images, m: m},
+		metrics:         m,
+		addr:            DefaultAddress,
+		shutdownTimeout: DefaultShutdownTimeout,
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	return s
+}
+
+// Start listens on the configured address and serves requests until the
+// context is cancelled. Then it stops accepting connections and waits for the
+// in flight requests to finish.
+func (s *Server) Start(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		log.Errorf(err, "cannot listen on %v", s.addr)
+		return err
+	}
+
+	return s.Serve(ctx, l)
+}
+
+// Serve is like Start but accepts connections from the given listener. The
+// listener is closed when Serve returns. It fails with ErrNoSessionSecret if
+// no session secret was set.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	if len(s.sessionSecret) == 0 {
+		l.Close()
+		log.Errorf(ErrNoSessionSecret, "cannot start server")
+		return ErrNoSessionSecret
+	}
+
+	srv := &http.Server{
+		Handler:      s.router(),
+		ReadTimeout:  s.readTimeout,
+		WriteTimeout: s.writeTimeout,
+		IdleTimeout:  s.idleTimeout,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.With(log.Fields{
+			"address": l.Addr().String(),
+			"tls":     s.certFile != "",
+		}).Infof("server started")
+
+		if s.certFile != "" {
+			errCh <- srv.ServeTLS(l, s.certFile, s.keyFile)
+		} else {
+			errCh <- srv.Serve(l)
+		}
+	}()
 
-	r.Get("/", s.gallery)
-	r.Get("/e", s.editor)
-	r.Post("/e", s.save)
-	r.Get("/diff", s.diff)
+	select {
+	case err := <-errCh:
+		log.Errorf(err, "server error")
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx := context.Background()
+	if s.shutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, s.shutdownTimeout)
+		defer cancel()
+	}
+
+	log.Infof("shutting down server")
+	err := srv.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Errorf(err, "could not gracefully shut down server")
+		srv.Close()
+		return err
+	}
+
+	err = <-errCh
+	if err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) router() http.Handler {
+	r := chi.NewRouter()
+	r.Use(s.requestID, logRequests, s.metrics.middleware)
+
+	// pages and saves need the identity of the client
+	r.Group(func(r chi.Router) {
+		r.Use(s.identify)
+		r.With(compress).Get("/", s.gallery)
+		r.Get("/e", s.editor)
+		r.Post("/e", s.save)
+		r.Get("/diff", s.diff)
+		r.With(compress).Get("/tree/{id:[0-9]+}", s.tree)
+		r.With(compress).Get("/u/{name}", s.userGallery)
+		r.Get("/login", s.loginPage)
+		r.With(s.checkCSRF).Post("/login", s.login)
+		r.With(s.checkCSRF).Post("/signup", s.signup)
+		r.With(s.checkCSRF).Post("/logout", s.logout)
+		r.Get("/login/{provider}", s.oauthLogin)
+		r.Get("/login/{provider}/callback", s.oauthCallback)
+	})
 	r.Get("/images/{id:[0-9]+}.png", s.image)
 	r.Get("/js/{name:[a-z]+\\.js}", s.js)
 	r.Get("/css/{name:[a-z]+\\.(css|png)}", s.css)
 	r.Get("/item/{effect:[0-9]+}", s.item)
 	r.Get("/item/{effect:[0-9]+}.{version:[0-9]+}", s.item)
+	r.Mount(apiPrefix, s.api())
+	r.Method("GET", "/metrics", s.metrics.handler())
+	r.Get("/healthz", s.healthz)
+	r.Get("/readyz", s.readyz)
 
-	err := http.ListenAndServe(":3000", r)
-	if err != nil {
-		log.Errorf(err, "server error")
-	}
+	return r
 }
 
-func loadTemplate(fs http.FileSystem, name string) (*template.Template, error) {
+func loadTemplate(
+	logger log.Logger,
+	fs http.FileSystem,
+	name string,
+) (*template.Template, error) {
 	f, err := fs.Open(name)
 	if err != nil {
-		log.Errorf(err, "cannot find asset %v", name)
+		logger.Errorf(err, "cannot find asset %v", name)
 		return nil, err
 	}
 	defer f.Close()
 
 	tmplText, err := ioutil.ReadAll(f)
 	if err != nil {
-		log.Errorf(err, "cannot load asset %v", name)
+		logger.Errorf(err, "cannot load asset %v", name)
 		return nil, err
 	}
 
 	tmpl, err := template.New("template").Parse(string(tmplText))
 	if err != nil {
-		log.Errorf(err, "cannot parse template %v", name)
+		logger.Errorf(err, "cannot parse template %v", name)
 		return nil, err
 	}
 
@@ -80,7 +260,34 @@ func loadTemplate(fs http.FileSystem, name string) (*template.Template, error) {
 }
 
 func (s *Server) gallery(w http.ResponseWriter, r *http.Request) {
-	tmpl, err := loadTemplate(s.fs, galleryPath)
+	query := r.URL.Query().Get("q")
+	gallery := Gallery{
+		Path:  "/",
+		Query: query,
+	}
+
+	s.renderGallery(w, r, gallery, func(db Store, page int) ([]Effect, error) {
+		if query != "" {
+			effects, _, err := db.Search(query, page, perPage)
+			return effects, err
+		}
+
+		return db.Effects(page, perPage)
+	})
+}
+
+// renderGallery shows the gallery template with the page of effects
+// returned by load.
+func (s *Server) renderGallery(
+	w http.ResponseWriter,
+	r *http.Request,
+	gallery Gallery,
+	load func(db Store, page int) ([]Effect, error),
+) {
+	db := s.store(r)
+	logger := requestLogger(r)
+
+	tmpl, err := loadTemplate(logger, s.fs, galleryPath)
 	if err != nil {
 		http.Error(w, http.StatusText(500), 500)
 		return
@@ -90,141 +297,185 @@ func (s *Server) gallery(w http.ResponseWriter, r *http.Request) {
 	if p, ok := r.URL.Query()["page"]; ok && len(p) == 1 {
 		page, err = strconv.Atoi(p[0])
 		if err != nil {
-			log.Errorf(err, "invalid page: %v", p)
+			logger.Errorf(err, "invalid page: %v", p)
 			page = 0
 		}
 	}
 
 	start := time.Now()
 
-	gallery := Gallery{
-		Page: page,
-	}
-
-	gallery.Effects, err = s.db.Effects(page, perPage)
+	gallery.Page = page
+	gallery.Effects, err = load(db, page)
 	if err != nil {
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
 
-	log.With(log.Fields{
+	logger.With(log.Fields{
 		"duration": time.Since(start),
 	}).Infof("database queried")
 
 	buf := new(bytes.Buffer)
 	err = tmpl.Execute(buf, gallery)
 	if err != nil {
-		log.Errorf(err, "cannot render template %v", galleryPath)
+		logger.Errorf(err, "cannot render template %v", galleryPath)
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
 
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	_, err = w.Write(buf.Bytes())
 	if err != nil {
-		log.Errorf(err, "cannot write page")
+		logger.Errorf(err, "cannot write page")
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
 }
 
 func (s *Server) image(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
+	logger := requestLogger(r)
 
-	name := filepath.Join(imagesDir, fmt.Sprintf("%v.png", id))
-	f, err := os.Open(name)
-	if err != nil {
-		log.Errorf(err, "cannot load image %v", name)
-		http.Error(w, http.StatusText(500), 500)
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	size := r.URL.Query().Get("size")
+	if _, ok := findImageSize(size); size != "" && !ok {
+		http.Error(w, http.StatusText(404), 404)
 		return
 	}
-	defer f.Close()
 
-	w.Header().Set("Content-Type", "image/png")
-	_, err = io.Copy(w, f)
+	name, err := findImage(s.images, uint(id), size)
 	if err != nil {
-		log.Errorf(err, "cannot write image %v", name)
+		logger.Errorf(err, "cannot find image %v", id)
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
-}
 
-func (s *Server) css(w http.ResponseWriter, r *http.Request) {
-	name := chi.URLParam(r, "name")
+	if s.redirectImages {
+		url, err := s.images.URL(name)
+		if err != nil {
+			logger.Errorf(err, "cannot get image url %v", name)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+
+		if url != "" {
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		}
+	}
 
-	path := fmt.Sprintf("/assets/css/%v", name)
-	f, err := s.fs.Open(path)
+	f, err := s.images.Get(name)
+	if err == ErrNotFound {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
 	if err != nil {
-		log.Errorf(err, "cannot load asset %v", path)
+		logger.Errorf(err, "cannot load image %v", name)
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
 	defer f.Close()
 
-	if strings.HasSuffix(name, ".png") {
-		w.Header().Set("Content-Type", "application/png")
-	} else {
-		w.Header().Set("Content-Type", "text/css")
-	}
-
-	_, err = io.Copy(w, f)
+	data, err := ioutil.ReadAll(f)
 	if err != nil {
-		log.Errorf(err, "cannot write asset %v", path)
+		logger.Errorf(err, "cannot read image %v", name)
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
+
+	// the image is saved with each version so it was modified when the last
+	// one was created. It is not needed if the client already has the image
+	// and effects imported without versions have no time.
+	var modified time.Time
+	etag := contentETag(data)
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		modified, err = s.store(r).LastVersionTime(id)
+		if err != nil && err != ErrNotFound {
+			logger.Errorf(err, "cannot load last version of %v", id)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", imageCacheControl)
+
+	ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	serveBytes(ww, r, data, etag, modified)
+	s.metrics.imageBytesServed.Add(float64(ww.BytesWritten()))
 }
 
-func (s *Server) js(w http.ResponseWriter, r *http.Request) {
+func (s *Server) css(w http.ResponseWriter, r *http.Request) {
 	name := chi.URLParam(r, "name")
 
-	path := fmt.Sprintf("/assets/js/%v", name)
-	f, err := s.fs.Open(path)
-	if err != nil {
-		log.Errorf(err, "cannot load asset %v", path)
-		http.Error(w, http.StatusText(500), 500)
-		return
+	contentType := "text/css"
+	if strings.HasSuffix(name, ".png") {
+		contentType = "application/png"
 	}
-	defer f.Close()
 
-	w.Header().Set("Content-Type", "text/javascript")
-	io.Copy(w, f)
+	s.serveAsset(w, r, fmt.Sprintf("/assets/css/%v", name), contentType)
+}
+
+func (s *Server) js(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	s.serveAsset(w, r, fmt.Sprintf("/assets/js/%v", name), "text/javascript")
 }
 
 func (s *Server) editor(w http.ResponseWriter, r *http.Request) {
-	path := "/assets/editor.html"
-	f, err := s.fs.Open(path)
+	s.serveAsset(w, r, "/assets/editor.html", "text/html")
+}
+
+func (s *Server) diff(w http.ResponseWriter, r *http.Request) {
+	s.serveAsset(w, r, "/assets/diff.html", "text/html")
+}
+
+func (s *Server) tree(w http.ResponseWriter, r *http.Request) {
+	db := s.store(r)
+	logger := requestLogger(r)
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	lineage, err := LoadLineage(db, id)
+	if err == ErrNotFound {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
 	if err != nil {
-		log.Errorf(err, "cannot load asset %v", path)
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
-	defer f.Close()
 
-	w.Header().Set("Content-Type", "text/html")
-	io.Copy(w, f)
-}
+	tmpl, err := loadTemplate(logger, s.fs, treePath)
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
 
-func (s *Server) diff(w http.ResponseWriter, r *http.Request) {
-	path := "/assets/diff.html"
-	f, err := s.fs.Open(path)
+	buf := new(bytes.Buffer)
+	err = tmpl.Execute(buf, lineage)
 	if err != nil {
-		log.Errorf(err, "cannot load asset %v", path)
+		logger.Errorf(err, "cannot render template %v", treePath)
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
-	defer f.Close()
 
 	w.Header().Set("Content-Type", "text/html")
-	io.Copy(w, f)
+	_, err = w.Write(buf.Bytes())
+	if err != nil {
+		logger.Errorf(err, "cannot write page")
+	}
 }
 
 type item struct {
 	Code   string `json:"code"`
 	User   string `json:"user"`
+	Owner  string `json:"owner,omitempty"`
 	Parent string `json:"parent"`
 }
 
 func (s *Server) item(w http.ResponseWriter, r *http.Request) {
+	db := s.store(r)
+
 	effectText := chi.URLParam(r, "effect")
 	effectID, _ := strconv.Atoi(effectText)
 
@@ -234,7 +485,7 @@ func (s *Server) item(w http.ResponseWriter, r *http.Request) {
 		versionID, _ = strconv.Atoi(versionText)
 	}
 
-	effect, err := s.db.Effect(effectID)
+	effect, err := db.Effect(effectID)
 	if err != nil {
 		http.Error(w, http.StatusText(404), 404)
 		return
@@ -255,9 +506,11 @@ func (s *Server) item(w http.ResponseWriter, r *http.Request) {
 		parent = ""
 	}
 
+	version := effect.Versions[versionID]
 	i := item{
-		Code:   effect.Versions[versionID].Code,
+		Code:   version.Code,
 		User:   effect.User,
+		Owner:  effect.Owner,
 		Parent: parent,
 	}
 
@@ -267,15 +520,26 @@ func (s *Server) item(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = w.Write(m)
-	if err != nil {
-		http.Error(w, http.StatusText(500), 500)
-		return
+	// the latest version changes when a new one is saved so clients must
+	// check it each time
+	cacheControl := revalidateCacheControl
+	if versionText != "" {
+		cacheControl = immutableCacheControl
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", cacheControl)
+	serveBytes(w, r, m, versionETag(int(effect.ID), version.Number),
+		version.Created)
 }
 
 type Gallery struct {
+	// Path is the address of the gallery used in the page links.
+	Path string
+	// Account is the name of the account when showing its effects.
+	Account string
 	Page    int
+	Query   string
 	Effects []Effect
 }
 
@@ -0,0 +1,325 @@
+package glsl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-log.v1"
+)
+
+// testSecret signs the sessions of the test servers.
+var testSecret = []byte("test secret")
+
+// testSession returns a session cookie for the user. Tests use an identity
+// per user name so the saves of the same user can update their effects.
+func testSession(user string) *http.Cookie {
+	return &http.Cookie{
+		Name:  sessionCookie,
+		Value: signSession(testSecret, "id-"+user, time.Now()),
+	}
+}
+
+const testImage = "data:image/png;base64,iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg=="
+
+func testServer(t *testing.T) (*Server, *MemoryStore, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(t, err)
+
+	images, err := NewFSImageStore(dir)
+	require.NoError(t, err)
+
+	store := NewMemoryStore()
+	server := NewServer(store, images, false, WithSessionSecret(testSecret))
+
+	return server, store, func() { os.RemoveAll(dir) }
+}
+
+func postSave(t *testing.T, h http.Handler, data saveCode) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, err := json.Marshal(data)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest("POST", "/e", bytes.NewReader(body))
+	req.AddCookie(testSession(data.User))
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	return res
+}
+
+func TestSave(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	res := postSave(t, h, saveCode{
+		Code:  "code 0",
+		Image: testImage,
+		User:  "owner",
+	})
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal("1.0", res.Body.String())
+
+	ok, err := server.images.Exists("1.png")
+	require.NoError(err)
+	require.True(ok)
+
+	// the owner adds a new version
+	res = postSave(t, h, saveCode{
+		CodeID: "1.0",
+		Code:   "code 1",
+		Image:  testImage,
+		User:   "owner",
+	})
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal("1.1", res.Body.String())
+
+	// other user forks the effect
+	res = postSave(t, h, saveCode{
+		CodeID: "1.1",
+		Code:   "fork",
+		Image:  testImage,
+		User:   "other",
+	})
+	require.Equal(http.StatusOK, res.Code)
+	require.Equal("2.0", res.Body.String())
+
+	effect, err := store.Effect(1)
+	require.NoError(err)
+	require.Len(effect.Versions, 2)
+	require.Equal("code 1", effect.Versions[1].Code)
+
+	fork, err := store.Effect(2)
+	require.NoError(err)
+	require.Equal(uint(1), fork.ParentID)
+	require.Equal(1, fork.ParentVersion)
+	require.Equal("other", fork.User)
+}
+
+func TestItem(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	store.Add(&Effect{
+		ID:            10,
+		ParentID:      5,
+		ParentVersion: 2,
+		User:          "user",
+		Versions: []Version{
+			{Number: 0, Code: "first"},
+			{Number: 1, Code: "second"},
+		},
+	})
+
+	tests := []struct {
+		path     string
+		status   int
+		expected item
+	}{
+		{
+			path:     "/item/10",
+			status:   http.StatusOK,
+			expected: item{Code: "second", User: "user", Parent: "5.2"},
+		},
+		{
+			path:     "/item/10.0",
+			status:   http.StatusOK,
+			expected: item{Code: "first", User: "user", Parent: "5.2"},
+		},
+		{
+			path:   "/item/10.2",
+			status: http.StatusNotFound,
+		},
+		{
+			path:   "/item/11",
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", test.path, nil)
+			res := httptest.NewRecorder()
+			h.ServeHTTP(res, req)
+
+			require.Equal(test.status, res.Code)
+			if test.status != http.StatusOK {
+				return
+			}
+
+			var i item
+			err := json.Unmarshal(res.Body.Bytes(), &i)
+			require.NoError(err)
+			require.Equal(test.expected, i)
+		})
+	}
+}
+
+func TestGallery(t *testing.T) {
+	require := require.New(t)
+
+	server, store, cleanup := testServer(t)
+	defer cleanup()
+	h := server.router()
+
+	for i := 0; i < perPage+1; i++ {
+		e, err := store.NewEffect(0, 0, "user", "")
+		require.NoError(err)
+		_, err = store.AddVersion(e, "code")
+		require.NoError(err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	require.Equal(http.StatusOK, res.Code)
+	body := res.Body.String()
+	require.Contains(body, "/e#41.0")
+	require.NotContains(body, "/e#1.0'")
+	require.NotContains(body, "Previous page")
+
+	req = httptest.NewRequest("GET", "/?page=1", nil)
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	require.Equal(http.StatusOK, res.Code)
+	body = res.Body.String()
+	require.Contains(body, "/e#1.0")
+	require.Contains(body, "Previous page")
+}
+
+// blockingStore makes Effects wait until release is closed.
+type blockingStore struct {
+	*MemoryStore
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) Effects(page, size int) ([]Effect, error) {
+	close(s.started)
+	<-s.release
+	return s.MemoryStore.Effects(page, size)
+}
+
+func (s *blockingStore) WithLogger(logger log.Logger) Store {
+	return s
+}
+
+func TestServerShutdown(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "glsl")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	images, err := NewFSImageStore(dir)
+	require.NoError(err)
+
+	store := &blockingStore{
+		MemoryStore: NewMemoryStore(),
+		started:     make(chan struct{}),
+		release:     make(chan struct{}),
+	}
+	server := NewServer(store, images, false,
+		WithTimeouts(time.Minute, time.Minute, time.Minute),
+		WithShutdownTimeout(10*time.Second),
+		WithSessionSecret(testSecret),
+	)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	url := "http://" + l.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(ctx, l)
+	}()
+
+	resCh := make(chan *http.Response, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		res, err := http.Get(url + "/")
+		if err != nil {
+			errCh <- err
+			return
+		}
+		resCh <- res
+	}()
+
+	// stop the server while the request is being served
+	<-store.started
+	cancel()
+
+	select {
+	case err := <-serveErr:
+		require.FailNow("server stopped before finishing requests", "%v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(store.release)
+
+	select {
+	case res := <-resCh:
+		res.Body.Close()
+		require.Equal(http.StatusOK, res.StatusCode)
+	case err := <-errCh:
+		require.NoError(err)
+	}
+
+	require.NoError(<-serveErr)
+
+	_, err = http.Get(url + "/")
+	require.Error(err)
+}
+
+func TestServerStartError(t *testing.T) {
+	require := require.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer l.Close()
+
+	server, _, cleanup := testServer(t)
+	defer cleanup()
+	WithAddress(l.Addr().String())(server)
+
+	err = server.Start(context.Background())
+	require.Error(err)
+}
+
+func TestServerSessionSecret(t *testing.T) {
+	require := require.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer l.Close()
+
+	server := NewServer(NewMemoryStore(), nil, false)
+	err = server.Serve(context.Background(), l)
+	require.Equal(ErrNoSessionSecret, err)
+
+	// the listener is closed
+	_, err = net.Dial("tcp", l.Addr().String())
+	require.Error(err)
+}